@@ -19,6 +19,10 @@ type KubeTrackConfiguration struct {
 
 	Events EventRule `json:"events,omitempty"`
 
+	// exempt the matching records from the retention of the database outputs
+	// +optional
+	Holds []LegalHold `json:"holds,omitempty"`
+
 	// +optional
 	Output []Output `json:"output"`
 }
@@ -34,6 +38,15 @@ type Rule struct {
 	OnDelete EventAction `json:"onDelete,omitempty"`
 
 	OnUpdate EventAction `json:"onUpdate,omitempty"`
+
+	// keep the records of the rule in the database outputs for ttlDays by event_time,
+	// 0 to fall back to the ttlDays of the output
+	// +optional
+	TTLDays int `json:"ttlDays,omitempty"`
+
+	// keep the last records of every object of the rule in the database outputs regardless of the retention
+	// +optional
+	KeepLast KeepLast `json:"keepLast,omitempty"`
 }
 
 type ObjectSelector struct {
@@ -55,6 +68,11 @@ type EventAction struct {
 
 	// save the json patch result of the diff or not
 	SaveJsonPatch bool `json:"saveJsonPatch,omitempty"`
+
+	// keep the records of the event type in the database outputs for ttlDays by event_time,
+	// 0 to fall back to the ttlDays of the rule
+	// +optional
+	TTLDays int `json:"ttlDays,omitempty"`
 }
 
 type FieldType string
@@ -75,12 +93,130 @@ type EventRule struct {
 	Namespaces []string `json:"namespaces,omitempty"`
 
 	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+
+	// keep the event records in the database outputs for ttlDays by event_time,
+	// 0 to fall back to the ttlDays of the output
+	// +optional
+	TTLDays int `json:"ttlDays,omitempty"`
+
+	// keep the Warning event records for warningTTLDays, 0 to fall back to ttlDays
+	// +optional
+	WarningTTLDays int `json:"warningTTLDays,omitempty"`
+}
+
+// LegalHold matches the records by all the conditions set, at least one condition is required
+type LegalHold struct {
+	Namespace string `json:"namespace,omitempty"`
+
+	UID string `json:"uid,omitempty"`
+
+	// the range of event_time, from is inclusive and to is exclusive
+	From *metav1.Time `json:"from,omitempty"`
+	To   *metav1.Time `json:"to,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
 }
 
 type Output struct {
-	Log      *OutputLog
-	Mysql    *OutputMysql
-	Postgres *OutputPostgres
+	Log           *OutputLog
+	Mysql         *OutputMysql
+	Postgres      *OutputPostgres
+	Sqlite        *OutputSqlite
+	Clickhouse    *OutputClickhouse
+	Kafka         *OutputKafka
+	Webhook       *OutputWebhook
+	Elasticsearch *OutputElasticsearch
+	File          *OutputFile
+	Loki          *OutputLoki
+	Notify        *OutputNotify
+	S3            *OutputS3
+	Syslog        *OutputSyslog
+	OTLP          *OutputOTLP
+	NATS          *OutputNATS
+	Redis         *OutputRedis
+
+	// the delivery queue of the output
+	Queue *OutputQueue `json:"queue,omitempty"`
+
+	// persist the records failed to be written on disk and replay them when the output recovers
+	// +optional
+	Spool *OutputSpool `json:"spool,omitempty"`
+
+	// only the records matching the filter are written to the output, all the records if not set
+	// +optional
+	Filter *OutputFilter `json:"filter,omitempty"`
+
+	// suppress the records with a recently seen idempotency key, the database outputs ignore the duplicated
+	// records by the unique index instead
+	// +optional
+	Dedupe *OutputDedupe `json:"dedupe,omitempty"`
+}
+
+type OutputDedupe struct {
+	// disable the deduplication, it's enabled by default for the outputs other than databases
+	Disabled bool `json:"disabled,omitempty"`
+
+	// number of the recent idempotency keys remembered, default 10000
+	Size int `json:"size,omitempty"`
+}
+
+// OutputFilter selects the records written to an output, all the conditions set must match,
+// a list matches when any of its items matches
+type OutputFilter struct {
+	// one of general, event, kubetrack
+	Sources []string `json:"sources,omitempty"`
+
+	// one of add, update, delete
+	EventTypes []string `json:"eventTypes,omitempty"`
+
+	// wildcards of the apiVersion of the object, e.g. apps/*
+	APIVersions []string `json:"apiVersions,omitempty"`
+
+	// wildcards of the kind of the object
+	Kinds []string `json:"kinds,omitempty"`
+
+	// wildcards of the namespace of the object
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// wildcards of the namespace of the object, the records in the matching namespaces are excluded
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+
+	// matches the labels of the object, the labels of the event itself for event records
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// the careField name to the wildcards of the value
+	Fields map[string][]string `json:"fields,omitempty"`
+
+	// only the records of Warning events
+	WarningOnly bool `json:"warningOnly,omitempty"`
+}
+
+type OverflowPolicy string
+
+const (
+	// OverflowPolicyBlock blocks the informer until the queue has room or blockTimeout elapses, then drops the incoming record
+	OverflowPolicyBlock OverflowPolicy = "block"
+	// OverflowPolicyDropOldest drops the oldest record in the queue
+	OverflowPolicyDropOldest OverflowPolicy = "dropOldest"
+	// OverflowPolicyDropNewest drops the incoming record
+	OverflowPolicyDropNewest OverflowPolicy = "dropNewest"
+)
+
+// OutputQueue is the bounded queue between the handlers and the output,
+// a slow output only stalls its own queue
+type OutputQueue struct {
+	// max number of pending records, default 1000
+	Capacity int `json:"capacity,omitempty"`
+
+	// number of workers writing the records, the records are not written in order when greater than 1, default 1
+	Workers int `json:"workers,omitempty"`
+
+	// what to do when the queue is full, one of block, dropOldest, dropNewest, default dropOldest,
+	// block stalls the delivery to the other outputs as well
+	Overflow OverflowPolicy `json:"overflow,omitempty"`
+
+	// how long the block policy waits for room, default 1s
+	BlockTimeout metav1.Duration `json:"blockTimeout,omitempty"`
 }
 
 type OutputLog struct {
@@ -88,11 +224,559 @@ type OutputLog struct {
 }
 
 type OutputMysql struct {
-	DSN     string `json:"dsn"`
-	TTLDays int    `json:"ttlDays"`
+	DSN string `json:"dsn"`
+
+	// keep the records no retention of the rules or events applies to for ttlDays by event_time, 0 to keep them
+	TTLDays int `json:"ttlDays"`
+
+	// insert the records in one statement when the number of pending records reaches flushSize,
+	// a failed batch is kept and retried, default 1 to insert every record synchronously
+	FlushSize int `json:"flushSize,omitempty"`
+
+	// insert the pending records every flushInterval, default 1s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+
+	DBPool `json:",inline"`
 }
 
+// PartitionInterval is the time range of each partition of the postgres events table
+type PartitionInterval string
+
+const (
+	PartitionIntervalDaily  PartitionInterval = "daily"
+	PartitionIntervalWeekly PartitionInterval = "weekly"
+)
+
 type OutputPostgres struct {
-	DSN     string `json:"dsn"`
-	TTLDays int    `json:"ttlDays"`
+	DSN string `json:"dsn"`
+
+	// keep the records no retention of the rules or events applies to for ttlDays by event_time, 0 to keep them,
+	// the partitions are dropped as a whole once the longest retention expires
+	TTLDays int `json:"ttlDays"`
+
+	// the events table is range partitioned on event_time, one of daily, weekly, default daily
+	PartitionInterval PartitionInterval `json:"partitionInterval,omitempty"`
+
+	// number of the upcoming partitions created ahead, default 3
+	PremakePartitions int `json:"premakePartitions,omitempty"`
+
+	// insert the records in one statement when the number of pending records reaches flushSize,
+	// a failed batch is kept and retried, default 1 to insert every record synchronously
+	FlushSize int `json:"flushSize,omitempty"`
+
+	// insert the pending records every flushInterval, default 1s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+
+	DBPool `json:",inline"`
+}
+
+type OutputSqlite struct {
+	// the database file path
+	Path string `json:"path"`
+
+	// keep the records no retention of the rules or events applies to for ttlDays by event_time, 0 to keep them
+	TTLDays int `json:"ttlDays"`
+
+	DBPool `json:",inline"`
+}
+
+// KeepLast keeps the last change records of every live object by uid, the event records are not counted,
+// the records of the deleted objects expire as usual, the objects of the cluster are live as long as the informers
+// list them
+type KeepLast struct {
+	// number of the last change records kept, 0 to disable
+	Records int `json:"records,omitempty"`
+
+	// number of the last change records with the full object kept, 0 to disable
+	Snapshots int `json:"snapshots,omitempty"`
+}
+
+// DBPool is the connection pool settings of a database output, every database output has its own pool,
+// the settings not set fall back to the DB_* environments
+type DBPool struct {
+	// default 100, 1 for sqlite
+	MaxOpenConns int `json:"maxOpenConns,omitempty"`
+
+	// default 10
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+
+	// default 30s
+	ConnMaxLifetime metav1.Duration `json:"connMaxLifetime,omitempty"`
+
+	// log all the sql statements
+	Debug bool `json:"debug,omitempty"`
+}
+
+// OutputClickhouse writes records through the clickhouse http interface
+type OutputClickhouse struct {
+	// the http interface address, e.g. http://127.0.0.1:8123
+	URL string `json:"url"`
+
+	// +optional
+	Username string `json:"username,omitempty"`
+	// +optional
+	Password string `json:"password,omitempty"`
+
+	// default "default"
+	Database string `json:"database,omitempty"`
+
+	// default events
+	Table string `json:"table,omitempty"`
+
+	// the table TTL clause on event_time, keep forever if not set
+	TTLDays int `json:"ttlDays"`
+
+	// flush when the number of pending records reaches flushSize, default 1000
+	FlushSize int `json:"flushSize,omitempty"`
+
+	// flush the pending records every flushInterval, default 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+
+	// request timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// retry times on 5xx and timeouts, default 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// initial retry backoff, doubled on every retry, default 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+type OutputLoki struct {
+	// the push api url, e.g. http://127.0.0.1:3100/loki/api/v1/push
+	URL string `json:"url"`
+
+	// the X-Scope-OrgID header for multi-tenant loki
+	TenantID string `json:"tenantID,omitempty"`
+
+	// +optional
+	Username string `json:"username,omitempty"`
+	// +optional
+	Password string `json:"password,omitempty"`
+	// +optional
+	BearerToken string `json:"bearerToken,omitempty"`
+
+	// static labels added to every stream
+	ExtraLabels map[string]string `json:"extraLabels,omitempty"`
+
+	// flush when the number of pending records reaches batchSize, default 500
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// flush the pending records every batchInterval, default 1s
+	BatchInterval metav1.Duration `json:"batchInterval,omitempty"`
+
+	// request timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// retry times on 5xx and timeouts, default 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// initial retry backoff, doubled on every retry, default 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+type NotifyPlatform string
+
+const (
+	NotifyPlatformSlack      NotifyPlatform = "slack"
+	NotifyPlatformMattermost NotifyPlatform = "mattermost"
+	NotifyPlatformTeams      NotifyPlatform = "teams"
+	NotifyPlatformDiscord    NotifyPlatform = "discord"
+)
+
+// OutputNotify posts the records to chat incoming webhooks
+type OutputNotify struct {
+	// one of slack, mattermost, teams, discord
+	Platform NotifyPlatform `json:"platform"`
+
+	WebhookURL string `json:"webhookURL"`
+
+	// go template of the message, the data has .Cluster, .Records, .Omitted and .Total
+	Template string `json:"template,omitempty"`
+
+	// records within the window are grouped into one message, default 10s
+	GroupWindow metav1.Duration `json:"groupWindow,omitempty"`
+
+	// the max number of records listed in one message, default 20
+	MaxRecordsPerMessage int `json:"maxRecordsPerMessage,omitempty"`
+
+	// request timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// retry times on 5xx and timeouts, default 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// initial retry backoff, doubled on every retry, default 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// OutputS3 archives the records as gzipped json lines objects in the S3 compatible bucket,
+// the objects are put under <prefix>/<cluster>/yyyy/mm/dd/hh/
+type OutputS3 struct {
+	// e.g. s3.amazonaws.com, or 127.0.0.1:9000 for minio
+	Endpoint string `json:"endpoint"`
+
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	Bucket string `json:"bucket"`
+
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	AccessKeyID     string `json:"accessKeyID"`
+	SecretAccessKey string `json:"secretAccessKey"`
+
+	// connect with plain http
+	Insecure bool `json:"insecure,omitempty"`
+
+	// use path style requests instead of virtual hosted style, which is usually needed by minio
+	PathStyle bool `json:"pathStyle,omitempty"`
+
+	// upload when the number of pending records reaches flushSize, default 10000
+	FlushSize int `json:"flushSize,omitempty"`
+
+	// upload the pending records every flushInterval, default 5m
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// OutputSyslog sends the records as RFC 5424 messages
+type OutputSyslog struct {
+	// one of udp, tcp, tls, default udp
+	Network string `json:"network,omitempty"`
+
+	// e.g. 127.0.0.1:514
+	Address string `json:"address"`
+
+	// syslog facility code, default 16 (local0)
+	Facility *int `json:"facility,omitempty"`
+
+	// the APP-NAME field, default kubetrack
+	AppName string `json:"appName,omitempty"`
+
+	// the HOSTNAME field, default the cluster name
+	Hostname string `json:"hostname,omitempty"`
+
+	// the private enterprise number used in the structured data IDs, default 32473
+	EnterpriseID int `json:"enterpriseID,omitempty"`
+
+	// dial and write timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// used when network is tls
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// OutputOTLP exports the records as OpenTelemetry log records
+type OutputOTLP struct {
+	// one of grpc, http, default grpc
+	Protocol string `json:"protocol,omitempty"`
+
+	// grpc: host:port, e.g. 127.0.0.1:4317
+	// http: the url, the path /v1/logs is used if not set, e.g. http://127.0.0.1:4318
+	Endpoint string `json:"endpoint"`
+
+	// connect without tls
+	Insecure bool `json:"insecure,omitempty"`
+
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// flush when the number of pending records reaches batchSize, default 512
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// flush the pending records every batchInterval, default 1s
+	BatchInterval metav1.Duration `json:"batchInterval,omitempty"`
+
+	// export timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// OutputNATS publishes the records to NATS JetStream
+type OutputNATS struct {
+	// nats server urls, e.g. nats://127.0.0.1:4222
+	Servers []string `json:"servers"`
+
+	// go template of the subject, the data is the output record,
+	// default kubetrack.{{ .ObjectRef.Kind | lower }}.{{ .ObjectRef.Namespace }},
+	// empty tokens are replaced with _
+	Subject string `json:"subject,omitempty"`
+
+	// create the stream on startup if set and it doesn't exist, the subjects missing in an existing stream are added,
+	// its other settings are kept, the stream must cover the subjects
+	// +optional
+	Stream string `json:"stream,omitempty"`
+
+	// subjects of the stream, default kubetrack.>
+	StreamSubjects []string `json:"streamSubjects,omitempty"`
+
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// +optional
+	Password string `json:"password,omitempty"`
+
+	// +optional
+	Token string `json:"token,omitempty"`
+
+	// path to the user credentials file
+	// +optional
+	CredsFile string `json:"credsFile,omitempty"`
+
+	// publish timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// encode the records as CloudEvents, one of structured, binary, disabled if not set
+	CloudEvents CloudEventsMode `json:"cloudEvents,omitempty"`
+}
+
+// OutputRedis appends the records to a redis stream
+type OutputRedis struct {
+	// host:port, e.g. 127.0.0.1:6379
+	Addr string `json:"addr"`
+
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// +optional
+	Password string `json:"password,omitempty"`
+
+	// +optional
+	DB int `json:"db,omitempty"`
+
+	// stream key, default kubetrack
+	Stream string `json:"stream,omitempty"`
+
+	// trim the stream to about maxLen entries on every XADD, default 100000, negative to disable
+	MaxLen int64 `json:"maxLen,omitempty"`
+
+	// trim the stream to exactly maxLen entries, it is slower than the approximate trimming
+	ExactTrim bool `json:"exactTrim,omitempty"`
+
+	// command timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+type KafkaKeyStrategy string
+
+const (
+	KafkaKeyStrategyUID            KafkaKeyStrategy = "uid"
+	KafkaKeyStrategyNamespacedName KafkaKeyStrategy = "namespacedName"
+	KafkaKeyStrategyKind           KafkaKeyStrategy = "kind"
+)
+
+type OutputKafka struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+
+	// how to build the message key, one of uid, namespacedName, kind, default uid
+	KeyStrategy KafkaKeyStrategy `json:"keyStrategy,omitempty"`
+
+	// required acks, one of none, one, all, default all
+	Acks string `json:"acks,omitempty"`
+
+	// compression codec, one of none, gzip, snappy, lz4, zstd, default none
+	Compression string `json:"compression,omitempty"`
+
+	// +optional
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// how long to wait for more messages before sending a batch, default 10ms
+	// +optional
+	BatchTimeout metav1.Duration `json:"batchTimeout,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// +optional
+	SASL *KafkaSASL `json:"sasl,omitempty"`
+
+	// encode the records as CloudEvents, one of structured, binary, disabled if not set
+	CloudEvents CloudEventsMode `json:"cloudEvents,omitempty"`
+}
+
+type KafkaSASL struct {
+	// one of plain, scram-sha-256, scram-sha-512
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+type OutputWebhook struct {
+	URL string `json:"url"`
+
+	// http method, default POST
+	Method string `json:"method,omitempty"`
+
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// go template of the request body, the data is the output record, or the list of records when batching,
+	// the body is the json of the data if not set
+	BodyTemplate string `json:"bodyTemplate,omitempty"`
+
+	// encode the records as CloudEvents, one of structured, binary, disabled if not set,
+	// bodyTemplate is not allowed and batching is only supported by structured mode
+	CloudEvents CloudEventsMode `json:"cloudEvents,omitempty"`
+
+	// sign the request body with HMAC-SHA256 if set
+	HMACSecret string `json:"hmacSecret,omitempty"`
+
+	// the header the signature is put in, default X-Kubetrack-Signature
+	SignatureHeader string `json:"signatureHeader,omitempty"`
+
+	// send records in batches when batchSize is greater than 1
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// flush the batch even if it's not full, default 5s
+	BatchInterval metav1.Duration `json:"batchInterval,omitempty"`
+
+	// request timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// retry times on 5xx and timeouts, default 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// initial retry backoff, doubled on every retry, default 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+type IndexRotation string
+
+const (
+	IndexRotationNone    IndexRotation = "none"
+	IndexRotationDaily   IndexRotation = "daily"
+	IndexRotationMonthly IndexRotation = "monthly"
+)
+
+// OutputElasticsearch works with both Elasticsearch and OpenSearch
+type OutputElasticsearch struct {
+	Addresses []string `json:"addresses"`
+
+	// +optional
+	Username string `json:"username,omitempty"`
+	// +optional
+	Password string `json:"password,omitempty"`
+	// +optional
+	APIKey string `json:"apiKey,omitempty"`
+
+	// index name prefix, default kubetrack
+	Index string `json:"index,omitempty"`
+
+	// append the event date to the index name, one of none, daily, monthly, default daily
+	IndexRotation IndexRotation `json:"indexRotation,omitempty"`
+
+	// don't install the index template on start up
+	SkipIndexTemplate bool `json:"skipIndexTemplate,omitempty"`
+
+	// flush when the number of pending records reaches flushSize, default 500
+	FlushSize int `json:"flushSize,omitempty"`
+
+	// flush the pending records every flushInterval, default 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+
+	// request timeout, default 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+
+	// retry times on 5xx and timeouts, default 3
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// initial retry backoff, doubled on every retry, default 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// OutputFile writes one json document per line to the file
+type OutputFile struct {
+	Path string `json:"path"`
+
+	// rotate the file when it reaches the size, default 100
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+
+	// rotate the file periodically even if it doesn't reach the max size, never if not set
+	RotateInterval metav1.Duration `json:"rotateInterval,omitempty"`
+
+	// delete the rotated files older than the days, never if not set
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+
+	// the max number of the rotated files to keep, keep all if not set
+	MaxBackups int `json:"maxBackups,omitempty"`
+
+	// gzip the rotated files
+	Compress bool `json:"compress,omitempty"`
+}
+
+type CloudEventsMode string
+
+const (
+	// CloudEventsModeStructured puts the whole event in the message body
+	CloudEventsModeStructured CloudEventsMode = "structured"
+	// CloudEventsModeBinary puts the event attributes in the message headers and the data in the body
+	CloudEventsModeBinary CloudEventsMode = "binary"
+)
+
+type FsyncPolicy string
+
+const (
+	// FsyncPolicyAlways syncs the segment file after every record
+	FsyncPolicyAlways FsyncPolicy = "always"
+	// FsyncPolicyInterval syncs the segment file every fsyncInterval
+	FsyncPolicyInterval FsyncPolicy = "interval"
+	// FsyncPolicyNever leaves it to the operating system
+	FsyncPolicyNever FsyncPolicy = "never"
+)
+
+// OutputSpool is the disk-backed write-ahead log of the records which the output failed to write
+type OutputSpool struct {
+	// the spool files are put in <dir>/<index>-<output name>, default /var/lib/kubetrack/spool
+	Dir string `json:"dir,omitempty"`
+
+	// max size of a segment file in bytes, default 64MiB
+	SegmentSize int64 `json:"segmentSize,omitempty"`
+
+	// max size of all the segment files in bytes, the oldest segments are evicted when exceeded, default 1GiB
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// one of always, interval, never, default interval
+	Fsync FsyncPolicy `json:"fsync,omitempty"`
+
+	// default 1s
+	FsyncInterval metav1.Duration `json:"fsyncInterval,omitempty"`
+
+	// how long to wait before replaying again after the output fails, default 5s
+	RetryInterval metav1.Duration `json:"retryInterval,omitempty"`
+}
+
+type TLSConfig struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
 }
@@ -20,9 +20,49 @@ func getCLIApp() *cli.App {
 			Usage: "config file path, default(/etc/kubetrack/config.yaml)",
 			Value: "/etc/kubetrack/config.yaml",
 		},
+		cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "the address the metrics and health endpoints bind to, disabled if empty, default(:9090)",
+			Value: ":9090",
+		},
+		cli.StringFlag{
+			Name:  "hold-api-addr",
+			Usage: "the address the api to place and release the legal holds binds to, disabled if empty",
+		},
+		cli.StringFlag{
+			Name:  "hold-api-token-file",
+			Usage: "the bearer tokens of the hold api, one \"token,user\" per line, required by the hold api",
+		},
+		cli.BoolFlag{
+			Name:  "hold-api-trust-remote-user",
+			Usage: "take the user of the hold api from the X-Remote-User header, only set it if the tokens are held by the authenticating proxies",
+		},
+		cli.StringFlag{
+			Name:  "hold-api-tls-cert-file",
+			Usage: "serve the hold api over tls with the certificate",
+		},
+		cli.StringFlag{
+			Name:  "hold-api-tls-key-file",
+			Usage: "the private key of the hold api certificate",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		return runMain(c)
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "apply the pending schema migrations of the database outputs and exit",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the statements of the pending migrations without applying them",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runMigrate(c)
+			},
+		},
+	}
 	return app
 }
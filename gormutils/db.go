@@ -1,9 +1,11 @@
 package gormutils
 
 import (
+	"context"
 	"os"
 	"time"
 
+	"github.com/major1201/kubetrack/third_party/gormsqlite"
 	"github.com/major1201/kubetrack/utils"
 	"github.com/pkg/errors"
 	"gorm.io/driver/mysql"
@@ -45,37 +47,48 @@ func init() {
 	}
 }
 
-func gormInit() {
-	dbDriver := os.Getenv(EnvDBDriver)
-	dbConnection := os.Getenv(EnvDBConnection)
-	dbConnMaxLifetime := time.Duration(utils.IntDefault(os.Getenv(EnvDBConnMaxLifetime), 30)) * time.Second // default 30s
-	dbMaxOpenConns := utils.IntDefault(os.Getenv(EnvDBMaxOpenConns), 100)                                   // default 100
-	dbMaxIdleConns := utils.IntDefault(os.Getenv(EnvDBMaxIdleConns), 10)                                    // default 10
-	dbDebug := utils.BoolDefault(os.Getenv(EnvDBDebug), false)                                              // default false
+// DBOptions is the options to open a database
+type DBOptions struct {
+	Driver          string
+	Connection      string
+	ConnMaxLifetime time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	Debug           bool
+}
 
-	dbLogLevel := gormLogger.Error
-	if dbDebug {
-		dbLogLevel = gormLogger.Info
+// DBOptionsFromEnv reads the database options from the environments
+func DBOptionsFromEnv() DBOptions {
+	return DBOptions{
+		Driver:          os.Getenv(EnvDBDriver),
+		Connection:      os.Getenv(EnvDBConnection),
+		ConnMaxLifetime: time.Duration(utils.IntDefault(os.Getenv(EnvDBConnMaxLifetime), 30)) * time.Second, // default 30s
+		MaxOpenConns:    utils.IntDefault(os.Getenv(EnvDBMaxOpenConns), 100),                                // default 100
+		MaxIdleConns:    utils.IntDefault(os.Getenv(EnvDBMaxIdleConns), 10),                                 // default 10
+		Debug:           utils.BoolDefault(os.Getenv(EnvDBDebug), false),                                    // default false
 	}
+}
 
-	if dbDriver == "" {
-		logger.Error(nil, "environment not set", "env", EnvDBDriver)
-		os.Exit(1)
+// Open opens a new database with its own connection pool
+func Open(opts DBOptions) (*gorm.DB, error) {
+	if opts.Driver == "" {
+		return nil, errors.New("db driver not set")
 	}
-	if dbConnection == "" {
-		logger.Error(nil, "environment not set", "env", EnvDBConnection)
-		os.Exit(1)
+	if opts.Connection == "" {
+		return nil, errors.New("db connection not set")
 	}
 
 	logger.Info("connecting db",
-		"driver", dbDriver,
-		"connection", dbConnection,
-		"conn_max_lifetime", dbConnMaxLifetime,
-		"max_open_conns", dbMaxOpenConns,
-		"max_idle_conns", dbMaxIdleConns,
+		"driver", opts.Driver,
+		"conn_max_lifetime", opts.ConnMaxLifetime,
+		"max_open_conns", opts.MaxOpenConns,
+		"max_idle_conns", opts.MaxIdleConns,
 	)
 
-	var err error
+	dbLogLevel := gormLogger.Error
+	if opts.Debug {
+		dbLogLevel = gormLogger.Info
+	}
 	gormConfig := &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{SingularTable: true},
 		Logger: gormLogger.New(NewLogrAdapter(logger), gormLogger.Config{
@@ -84,30 +97,39 @@ func gormInit() {
 	}
 
 	var dialector gorm.Dialector
-	switch dbDriver {
+	switch opts.Driver {
 	case "postgres":
-		dialector = postgres.Open(dbConnection)
+		dialector = postgres.Open(opts.Connection)
 	case "mysql":
-		dialector = mysql.Open(dbConnection)
+		dialector = mysql.Open(opts.Connection)
+	case "sqlite":
+		dialector = gormsqlite.Open(opts.Connection)
 	default:
-		logger.Error(nil, "unknown db driver", "driver", dbDriver)
-		os.Exit(1)
+		return nil, errors.Errorf("unknown db driver: %s", opts.Driver)
 	}
 
-	_db, err = gorm.Open(dialector, gormConfig)
+	db, err := gorm.Open(dialector, gormConfig)
 	if err != nil {
-		logger.Error(errors.WithStack(err), "db init failed")
-		os.Exit(1)
+		return nil, errors.WithStack(err)
 	}
 
-	sqlDB, err := _db.DB()
+	sqlDB, err := db.DB()
 	if err != nil {
-		logger.Error(errors.WithStack(err), "get sql db failed")
+		return nil, errors.WithStack(err)
+	}
+	sqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	sqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+	return db, nil
+}
+
+func gormInit() {
+	var err error
+	_db, err = Open(DBOptionsFromEnv())
+	if err != nil {
+		logger.Error(err, "db init failed")
 		os.Exit(1)
 	}
-	sqlDB.SetConnMaxLifetime(dbConnMaxLifetime)
-	sqlDB.SetMaxOpenConns(dbMaxOpenConns)
-	sqlDB.SetMaxIdleConns(dbMaxIdleConns)
 }
 
 // GetDB get gorm DB client
@@ -117,3 +139,22 @@ func GetDB() *gorm.DB {
 	}
 	return _db
 }
+
+// WithConn runs fn with a session on a dedicated connection of the pool, for the session level locks and the temporary tables,
+// fn must not use db, which waits for the connection if the pool has only one
+func WithConn(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get connection failed")
+	}
+	defer conn.Close()
+
+	tx := db.Session(&gorm.Session{Context: ctx})
+	tx.Statement.ConnPool = conn
+	return fn(tx)
+}
@@ -0,0 +1,165 @@
+package gormutils
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned schema change, the migrations are applied in the order of the versions
+// and every version is applied only once
+type Migration struct {
+	Version int
+	Name    string
+
+	// the statements of each dialect: mysql, postgres or sqlite, a dialect without statements has nothing to change,
+	// they're applied in a transaction, but the DDL statements of mysql commit implicitly, a mysql migration
+	// failed halfway has to be finished or reverted by hand
+	Statements map[string][]string
+
+	// tells if the change is already in the database, e.g. made by the AutoMigrate of the older versions,
+	// the migration is then recorded as applied without running the statements
+	// +optional
+	Applied func(db *gorm.DB) bool
+}
+
+// SchemaMigration is the record of an applied migration
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"type:varchar(255)"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// the name of the lock serializing the migrations of the replicas
+const migrationLock = "kubetrack_schema_migrations"
+
+// Migrate applies the pending migrations, the statements are only printed to w in dry run mode,
+// the replicas migrating the same database at once wait for each other by a lock of the database
+func Migrate(db *gorm.DB, migrations []Migration, dryRun bool, w io.Writer) error {
+	if dryRun {
+		return migrate(db, migrations, dryRun, w)
+	}
+	return WithConn(db, func(tx *gorm.DB) error {
+		unlock, err := lockMigrations(tx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return migrate(tx, migrations, dryRun, w)
+	})
+}
+
+// lockMigrations takes the session level lock, db must be on a dedicated connection,
+// sqlite is not locked as it has only one writer
+func lockMigrations(db *gorm.DB) (func(), error) {
+	var lock, unlock string
+	switch db.Dialector.Name() {
+	case "postgres":
+		lock, unlock = "SELECT pg_advisory_lock(hashtext(?))", "SELECT pg_advisory_unlock(hashtext(?))"
+	case "mysql":
+		lock, unlock = "SELECT GET_LOCK(?, -1)", "SELECT RELEASE_LOCK(?)"
+	default:
+		return func() {}, nil
+	}
+
+	logger.Info("waiting for the migration lock", "dialect", db.Dialector.Name())
+	var err error
+	if db.Dialector.Name() == "mysql" {
+		// GET_LOCK returns 1 once locked
+		var locked sql.NullInt64
+		err = db.Raw(lock, migrationLock).Row().Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = errors.New("GET_LOCK failed")
+		}
+	} else {
+		err = db.Exec(lock, migrationLock).Error
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "take the migration lock failed")
+	}
+	return func() {
+		if err := db.Exec(unlock, migrationLock).Error; err != nil {
+			logger.Error(err, "release the migration lock failed")
+		}
+	}, nil
+}
+
+func migrate(db *gorm.DB, migrations []Migration, dryRun bool, w io.Writer) error {
+	dialect := db.Dialector.Name()
+	if w == nil {
+		w = io.Discard
+	}
+
+	applied := map[int]bool{}
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		var records []SchemaMigration
+		if err := db.Find(&records).Error; err != nil {
+			return errors.Wrap(err, "list schema migrations failed")
+		}
+		for _, record := range records {
+			applied[record.Version] = true
+		}
+	} else if dryRun {
+		fmt.Fprintln(w, "-- table schema_migrations will be created")
+	} else if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return errors.Wrap(err, "create schema_migrations table failed")
+	}
+
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+
+	pending := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		pending++
+
+		if m.Applied != nil && m.Applied(db) {
+			fmt.Fprintf(w, "-- %d %s: already in the database, recorded as applied\n", m.Version, m.Name)
+			if !dryRun {
+				logger.Info("migration already in the database, recording it as applied", "dialect", dialect, "version", m.Version, "name", m.Name)
+				if err := db.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+					return errors.Wrapf(err, "record migration %d failed", m.Version)
+				}
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "-- %d %s\n", m.Version, m.Name)
+		for _, stmt := range m.Statements[dialect] {
+			fmt.Fprintf(w, "%s;\n", stmt)
+		}
+		if dryRun {
+			continue
+		}
+
+		logger.Info("applying migration", "dialect", dialect, "version", m.Version, "name", m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, stmt := range m.Statements[dialect] {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return errors.Wrapf(err, "exec %q failed", stmt)
+				}
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return errors.Wrapf(err, "apply migration %d %s failed", m.Version, m.Name)
+		}
+	}
+
+	if pending == 0 {
+		fmt.Fprintln(w, "-- the schema is up to date")
+	}
+	return nil
+}
@@ -45,6 +45,7 @@ func (h *EventHandler) OnAdd(cluster kubecache.Cluster, obj any) {
 		return
 	}
 
+	idempotencyKey := output.NewIdempotencyKey(h.config.Cluster, output.SourceTypeEvent, output.EventTypeAdd, unstrObj.GetUID(), unstrObj.GetResourceVersion())
 	h.pruneObject(unstrObj)
 
 	// main tree
@@ -55,6 +56,9 @@ func (h *EventHandler) OnAdd(cluster kubecache.Cluster, obj any) {
 		Source:    output.SourceTypeEvent,
 		Object:    unstrObj.Object,
 		Message:   h.displayOfMessage(event),
+		Labels:    unstrObj.GetLabels(),
+
+		IdempotencyKey: idempotencyKey,
 	}
 
 	// write output
@@ -77,6 +81,7 @@ func (h *EventHandler) OnUpdate(cluster kubecache.Cluster, oldObj, newObj any) {
 	newEventIf, _ := kube.GetScheme().ConvertToVersion(newObj.(runtime.Object), runtime.GroupVersioner(schema.GroupVersions(kube.GetScheme().PrioritizedVersionsAllGroups())))
 	newEvent := newEventIf.(*corev1.Event)
 
+	idempotencyKey := output.NewIdempotencyKey(h.config.Cluster, output.SourceTypeEvent, output.EventTypeUpdate, newUnstrObj.GetUID(), newUnstrObj.GetResourceVersion())
 	h.pruneObject(oldUnstrObj)
 	h.pruneObject(newUnstrObj)
 
@@ -89,6 +94,9 @@ func (h *EventHandler) OnUpdate(cluster kubecache.Cluster, oldObj, newObj any) {
 		Object:    newUnstrObj.Object,
 		Message:   h.displayOfMessage(newEvent),
 		Diff:      cmp.Diff(oldUnstrObj.Object, newUnstrObj.Object),
+		Labels:    newUnstrObj.GetLabels(),
+
+		IdempotencyKey: idempotencyKey,
 	}
 
 	// save json patch
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"sync"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -13,6 +14,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -22,12 +24,17 @@ type GeneralHandler struct {
 	config    config.KubeTrackConfiguration
 	outputers []output.Output
 	synced    bool
+
+	// the uids of the objects of the rules in the cluster, see LiveUIDs
+	liveMu sync.RWMutex
+	live   map[types.UID]struct{}
 }
 
 func NewGeneralHandler(conf config.KubeTrackConfiguration, outputers []output.Output) *GeneralHandler {
 	return &GeneralHandler{
 		config:    conf,
 		outputers: outputers,
+		live:      map[types.UID]struct{}{},
 	}
 }
 
@@ -35,19 +42,21 @@ func (h *GeneralHandler) OnAdd(cluster kubecache.Cluster, obj any) {
 	eventTime := time.Now()
 	unstrObj := obj.(*unstructured.Unstructured)
 
-	// filter initial list
-	if h.isHistoryAdd(unstrObj) {
+	rule := h.getRule(unstrObj)
+	if rule == nil {
 		return
 	}
+	h.setLive(unstrObj.GetUID(), true)
 
-	rule := h.getRule(unstrObj)
-	if rule == nil {
+	// filter initial list
+	if h.isHistoryAdd(unstrObj) {
 		return
 	}
 	eventAction := rule.OnCreate
 
 	// main tree
 	objRef := BuildObjectReference(unstrObj)
+	idempotencyKey := output.NewIdempotencyKey(h.config.Cluster, output.SourceTypeGeneral, output.EventTypeAdd, unstrObj.GetUID(), unstrObj.GetResourceVersion())
 	h.pruneObject(unstrObj)
 	content := output.OutputStruct{
 		EventTime: eventTime,
@@ -55,6 +64,9 @@ func (h *GeneralHandler) OnAdd(cluster kubecache.Cluster, obj any) {
 		EventType: output.EventTypeAdd,
 		Source:    output.SourceTypeGeneral,
 		Fields:    BuildFieldsMap(unstrObj, rule.CareFields),
+		Labels:    unstrObj.GetLabels(),
+
+		IdempotencyKey: idempotencyKey,
 	}
 
 	if eventAction.SaveFullObject {
@@ -78,10 +90,12 @@ func (h *GeneralHandler) OnUpdate(cluster kubecache.Cluster, oldObj, newObj any)
 	if rule == nil {
 		return
 	}
+	h.setLive(newUnstrObj.GetUID(), true)
 	eventAction := rule.OnUpdate
 
 	// main tree
 	objRef := BuildObjectReference(oldUnstrObj)
+	idempotencyKey := output.NewIdempotencyKey(h.config.Cluster, output.SourceTypeGeneral, output.EventTypeUpdate, newUnstrObj.GetUID(), newUnstrObj.GetResourceVersion())
 	h.pruneObject(oldUnstrObj)
 	h.pruneObject(newUnstrObj)
 	content := output.OutputStruct{
@@ -90,6 +104,9 @@ func (h *GeneralHandler) OnUpdate(cluster kubecache.Cluster, oldObj, newObj any)
 		EventType: output.EventTypeUpdate,
 		Source:    output.SourceTypeGeneral,
 		Fields:    BuildFieldsMap(newUnstrObj, rule.CareFields),
+		Labels:    newUnstrObj.GetLabels(),
+
+		IdempotencyKey: idempotencyKey,
 	}
 	if eventAction.SaveFullObject {
 		content.Object = newUnstrObj.Object
@@ -139,6 +156,7 @@ func (h *GeneralHandler) OnDelete(cluster kubecache.Cluster, obj any) {
 
 func (h *GeneralHandler) onDeleteUnstr(_ kubecache.Cluster, unstrObj *unstructured.Unstructured, isTombstone bool) {
 	eventTime := time.Now()
+	h.setLive(unstrObj.GetUID(), false)
 
 	rule := h.getRule(unstrObj)
 	if rule == nil {
@@ -148,6 +166,7 @@ func (h *GeneralHandler) onDeleteUnstr(_ kubecache.Cluster, unstrObj *unstructur
 
 	// main tree
 	objRef := BuildObjectReference(unstrObj)
+	idempotencyKey := output.NewIdempotencyKey(h.config.Cluster, output.SourceTypeGeneral, output.EventTypeDelete, unstrObj.GetUID(), unstrObj.GetResourceVersion())
 	h.pruneObject(unstrObj)
 	content := output.OutputStruct{
 		EventTime: eventTime,
@@ -156,6 +175,9 @@ func (h *GeneralHandler) onDeleteUnstr(_ kubecache.Cluster, unstrObj *unstructur
 		Source:    output.SourceTypeGeneral,
 		Fields:    BuildFieldsMap(unstrObj, rule.CareFields),
 		Message:   Ternary(isTombstone, " [tombstone]", ""),
+		Labels:    unstrObj.GetLabels(),
+
+		IdempotencyKey: idempotencyKey,
 	}
 
 	if eventAction.SaveFullObject {
@@ -180,9 +202,32 @@ func (h *GeneralHandler) onDeleteFinalStateUnknown(cluster kubecache.Cluster, to
 }
 
 func (h *GeneralHandler) SetSyned(synced bool) {
+	h.liveMu.Lock()
+	defer h.liveMu.Unlock()
 	h.synced = synced
 }
 
+func (h *GeneralHandler) setLive(uid types.UID, live bool) {
+	h.liveMu.Lock()
+	defer h.liveMu.Unlock()
+	if live {
+		h.live[uid] = struct{}{}
+	} else {
+		delete(h.live, uid)
+	}
+}
+
+// LiveUIDs returns the uids of the objects of the rules in the cluster, and false until the initial lists are synced
+func (h *GeneralHandler) LiveUIDs() ([]string, bool) {
+	h.liveMu.RLock()
+	defer h.liveMu.RUnlock()
+	uids := make([]string, 0, len(h.live))
+	for uid := range h.live {
+		uids = append(uids, string(uid))
+	}
+	return uids, h.synced
+}
+
 func (h *GeneralHandler) getRule(obj runtime.Object) *config.Rule {
 	// get the first rule matches
 	for _, rule := range h.config.Rules {
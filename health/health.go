@@ -0,0 +1,87 @@
+package health
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Check returns nil if the component is healthy
+type Check func(ctx context.Context) error
+
+const checkTimeout = 5 * time.Second
+
+var (
+	mu     sync.RWMutex
+	checks = map[string]Check{}
+)
+
+// Register registers the readiness check of a component, a suffix is appended to the name if it's taken,
+// the registered name is returned
+func Register(name string, check Check) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registered := name
+	for i := 2; checks[registered] != nil; i++ {
+		registered = fmt.Sprintf("%s-%d", name, i)
+	}
+	checks[registered] = check
+	return registered
+}
+
+// Status is the result of a readiness check
+type Status struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// Statuses runs all the readiness checks
+func Statuses(ctx context.Context) []Status {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	statuses := make([]Status, 0, len(checks))
+	for name, check := range checks {
+		status := Status{Name: name, Ready: true}
+		if err := check(ctx); err != nil {
+			status.Ready = false
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b Status) int { return cmp.Compare(a.Name, b.Name) })
+	return statuses
+}
+
+// LivenessHandler always reports ok while the process is serving, a degraded output is not a reason to restart
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// ReadinessHandler reports the status of all the components, responds 503 if any of them is not ready
+func ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		statuses := Statuses(ctx)
+		code := http.StatusOK
+		for _, status := range statuses {
+			if !status.Ready {
+				code = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(statuses)
+	})
+}
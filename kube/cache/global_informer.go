@@ -86,21 +86,22 @@ func newClusterHandlerWrapper(cluster Cluster, unit ResourceUnit, resourceEventH
 	}
 }
 
+// the handlers are called synchronously to keep the order of the notifications and to apply backpressure
 func (w *clusterHandlerWrapper) OnAdd(obj interface{}) {
 	for _, handler := range w.resourceEventHandlers {
-		go handler.OnAdd(w.cluster, obj)
+		handler.OnAdd(w.cluster, obj)
 	}
 }
 
 func (w *clusterHandlerWrapper) OnUpdate(oldObj, newObj interface{}) {
 	for _, handler := range w.resourceEventHandlers {
-		go handler.OnUpdate(w.cluster, oldObj, newObj)
+		handler.OnUpdate(w.cluster, oldObj, newObj)
 	}
 }
 
 func (w *clusterHandlerWrapper) OnDelete(obj interface{}) {
 	for _, handler := range w.resourceEventHandlers {
-		go handler.OnDelete(w.cluster, obj)
+		handler.OnDelete(w.cluster, obj)
 	}
 }
 
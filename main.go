@@ -2,6 +2,9 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/major1201/kubetrack/config"
@@ -39,6 +42,12 @@ func runMain(c *cli.Context) error {
 		return err
 	}
 
+	serveHTTP(c.String("metrics-addr"))
+	if err := serveHoldAPI(c.String("hold-api-addr"), c.String("hold-api-token-file"), c.Bool("hold-api-trust-remote-user"),
+		c.String("hold-api-tls-cert-file"), c.String("hold-api-tls-key-file")); err != nil {
+		return err
+	}
+
 	gi = kubecache.NewGlobalInformer(kube.GetScheme())
 
 	client, err := kube.NewClientInCluster()
@@ -49,18 +58,67 @@ func runMain(c *cli.Context) error {
 
 	// make outputs
 	var out []output.Output
-	for _, outConfig := range ktconfig.Output {
+	for i, outConfig := range ktconfig.Output {
+		var o output.Output
+		// the database outputs ignore the duplicated records by the unique index
+		dedupe := outConfig.Dedupe == nil || !outConfig.Dedupe.Disabled
 		switch {
 		case outConfig.Log != nil:
-			out = append(out, output.NewLogOutput(outConfig.Log))
+			o = output.NewLogOutput(outConfig.Log)
 		case outConfig.Mysql != nil:
-			out = append(out, output.NewMysqlOutput(&ktconfig, outConfig.Mysql))
+			o = output.NewMysqlOutput(&ktconfig, outConfig.Mysql)
+			dedupe = false
 		case outConfig.Postgres != nil:
-			out = append(out, output.NewPostgresOutput(&ktconfig, outConfig.Postgres))
+			o = output.NewPostgresOutput(&ktconfig, outConfig.Postgres)
+			dedupe = false
+		case outConfig.Sqlite != nil:
+			o = output.NewSqliteOutput(&ktconfig, outConfig.Sqlite)
+			dedupe = false
+		case outConfig.Clickhouse != nil:
+			o = output.NewClickhouseOutput(&ktconfig, outConfig.Clickhouse)
+		case outConfig.Kafka != nil:
+			o = output.NewKafkaOutput(&ktconfig, outConfig.Kafka)
+		case outConfig.Webhook != nil:
+			o = output.NewWebhookOutput(&ktconfig, outConfig.Webhook)
+		case outConfig.Elasticsearch != nil:
+			o = output.NewElasticsearchOutput(&ktconfig, outConfig.Elasticsearch)
+		case outConfig.File != nil:
+			o = output.NewFileOutput(&ktconfig, outConfig.File)
+		case outConfig.Loki != nil:
+			o = output.NewLokiOutput(&ktconfig, outConfig.Loki)
+		case outConfig.Notify != nil:
+			o = output.NewNotifyOutput(&ktconfig, outConfig.Notify)
+		case outConfig.S3 != nil:
+			o = output.NewS3Output(&ktconfig, outConfig.S3)
+		case outConfig.Syslog != nil:
+			o = output.NewSyslogOutput(&ktconfig, outConfig.Syslog)
+		case outConfig.OTLP != nil:
+			o = output.NewOTLPOutput(&ktconfig, outConfig.OTLP)
+		case outConfig.NATS != nil:
+			o = output.NewNATSOutput(&ktconfig, outConfig.NATS)
+		case outConfig.Redis != nil:
+			o = output.NewRedisOutput(&ktconfig, outConfig.Redis)
+		default:
+			log.L.Error(nil, "no output type set", "index", i)
+			os.Exit(1)
+		}
+		if outConfig.Spool != nil {
+			o = output.NewSpooledOutput(i, o, outConfig.Spool)
 		}
+		// each output has its own queue, so a slow output won't stall the others
+		o = output.NewQueuedOutput(i, o, outConfig.Queue)
+		if dedupe {
+			o = output.NewDedupedOutput(o, outConfig.Dedupe)
+		}
+		if outConfig.Filter != nil {
+			o = output.NewFilteredOutput(o, outConfig.Filter)
+		}
+		out = append(out, o)
 	}
 
 	generalHandler := handler.NewGeneralHandler(ktconfig, out)
+	// keepLast keeps the last records of the objects the informers know
+	output.SetLiveObjects(generalHandler.LiveUIDs)
 	eventHandler := handler.NewEventHandler(ktconfig, out)
 
 	// make units
@@ -126,11 +184,37 @@ func runMain(c *cli.Context) error {
 
 	go waitAllSynced(generalHandler)
 
-	<-make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.L.Info("shutting down", "signal", (<-sig).String())
+	closeOutputs(out)
 
 	return nil
 }
 
+// closeOutputs delivers the records queued or batched in memory before exiting
+func closeOutputs(outs []output.Output) {
+	var wg sync.WaitGroup
+	for _, o := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := output.CloseOutput(o); err != nil {
+				log.L.Error(err, "close output failed", "name", o.Name())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func runMigrate(c *cli.Context) error {
+	ktconfig, err := config.LoadFromFile(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	return output.MigrateOutputs(&ktconfig, c.Bool("dry-run"), os.Stdout)
+}
+
 func waitAllSynced(handler *handler.GeneralHandler) {
 	t := time.NewTicker(100 * time.Millisecond)
 	startTime := time.Now()
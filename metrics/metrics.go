@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kubetrack"
+
+var (
+	// OutputQueueDepth is the number of records waiting in the queue of each output
+	OutputQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "queue_depth",
+		Help:      "Number of records waiting in the output queue.",
+	}, []string{"output", "index"})
+
+	// OutputQueueCapacity is the capacity of the queue of each output
+	OutputQueueCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "queue_capacity",
+		Help:      "Capacity of the output queue.",
+	}, []string{"output", "index"})
+
+	// OutputRecordsDropped counts the records dropped by the overflow policy
+	OutputRecordsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "records_dropped_total",
+		Help:      "Number of records dropped because the output queue is full.",
+	}, []string{"output", "index", "policy"})
+
+	// OutputRecordsWritten counts the records written by each output
+	OutputRecordsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "records_written_total",
+		Help:      "Number of records written by the output.",
+	}, []string{"output", "index"})
+
+	// OutputWriteErrors counts the failed writes of each output
+	OutputWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "write_errors_total",
+		Help:      "Number of records failed to be written by the output.",
+	}, []string{"output", "index"})
+
+	// OutputSpoolBytes is the size of the segment files in the spool of each output
+	OutputSpoolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "spool_bytes",
+		Help:      "Size of the segment files in the output spool.",
+	}, []string{"output", "index"})
+
+	// OutputSpoolEvictedSegments counts the segments evicted because the spool is full
+	OutputSpoolEvictedSegments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "spool_evicted_segments_total",
+		Help:      "Number of spool segments evicted because the max size is exceeded.",
+	}, []string{"output", "index"})
+
+	// OutputUp tells if the output is connected to its backend
+	OutputUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "output",
+		Name:      "up",
+		Help:      "Whether the output is connected to its backend (1) or degraded (0).",
+	}, []string{"output"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		OutputQueueDepth,
+		OutputQueueCapacity,
+		OutputRecordsDropped,
+		OutputRecordsWritten,
+		OutputWriteErrors,
+		OutputSpoolBytes,
+		OutputSpoolEvictedSegments,
+		OutputUp,
+	)
+}
+
+// Handler returns the http handler of the prometheus metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,187 @@
+package output
+
+import (
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+// batcher collects items and flushes them once the batch is full or the flush interval elapsed
+type batcher[T any] struct {
+	name     string
+	size     int
+	interval time.Duration
+	flush    func(items []T) error
+
+	// keep the items of the failed flush and retry them with the next one, otherwise they're handed to failed or dropped,
+	// the items failed with a permanentError are dropped
+	keepFailed bool
+
+	mu     sync.Mutex
+	failed func(items []T) // takes the items of the failed flushes, e.g. to spool them
+	items  []T
+	err    error // of the last flush, the pending items are flushed with every Add until it succeeds
+	done   chan struct{}
+	closed sync.Once
+}
+
+func newBatcher[T any](name string, size int, interval time.Duration, flush func(items []T) error) *batcher[T] {
+	b := &batcher[T]{
+		name:     name,
+		size:     size,
+		interval: interval,
+		flush:    flush,
+	}
+	b.start()
+	return b
+}
+
+func (b *batcher[T]) start() {
+	b.done = make(chan struct{})
+	if b.interval > 0 {
+		go b.run()
+	}
+}
+
+// handedOverError is returned when the flush failed and all its items, including the added one, are handed to failed
+type handedOverError struct {
+	error
+}
+
+func (e handedOverError) Unwrap() error {
+	return e.error
+}
+
+// handleFailed hands the items of the failed flushes to fn instead of dropping them
+func (b *batcher[T]) handleFailed(fn func(items []T)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failed = fn
+}
+
+// Add appends the item to the batch, the batch is flushed in the caller goroutine when it's full.
+// If keepFailed and the flush fails, the other items are kept and the error is returned for the item,
+// which is not kept, so the caller can handle it
+func (b *batcher[T]) Add(item T) error {
+	b.mu.Lock()
+	b.items = append(b.items, item)
+	if len(b.items) < b.size && b.err == nil {
+		b.mu.Unlock()
+		return nil
+	}
+	items := b.items
+	b.items = nil
+	b.mu.Unlock()
+
+	return b.flushItems(items, true)
+}
+
+// Flush flushes all the pending items
+func (b *batcher[T]) Flush() error {
+	b.mu.Lock()
+	items := b.items
+	b.items = nil
+	b.mu.Unlock()
+
+	if len(items) == 0 {
+		return nil
+	}
+	return b.flushItems(items, false)
+}
+
+// flushItems flushes the items, the last one is the added one if added
+func (b *batcher[T]) flushItems(items []T, added bool) error {
+	err := b.flush(items)
+	if b.keepFailed {
+		if isPermanent(err) {
+			return b.flushEach(items, added)
+		}
+		if added {
+			items = items[:len(items)-1]
+		}
+		b.settle(items, err)
+		return err
+	}
+	if err == nil {
+		return nil
+	}
+	err = errors.Wrapf(err, "flush %d items failed", len(items))
+
+	b.mu.Lock()
+	failed := b.failed
+	b.mu.Unlock()
+	if failed == nil {
+		return err
+	}
+	failed(items)
+	return handedOverError{err}
+}
+
+// Close stops flushing periodically and flushes the pending items, it can be called more than once
+func (b *batcher[T]) Close() error {
+	b.closed.Do(func() { close(b.done) })
+	b.mu.Lock()
+	n := len(b.items)
+	b.mu.Unlock()
+	return errors.Wrapf(b.Flush(), "flush %d pending items failed", n)
+}
+
+// flushEach flushes the items one by one after the batch failed with a permanent error, so the bad items don't fail
+// the others. The bad items are dropped, the items failed with the other errors are kept except the added one,
+// whose error is returned
+func (b *batcher[T]) flushEach(items []T, added bool) error {
+	var kept []T
+	var keptErr, addedErr error
+	for i, item := range items {
+		err := b.flush([]T{item})
+		if added && i == len(items)-1 {
+			addedErr = err
+			continue
+		}
+		switch {
+		case err == nil:
+		case isPermanent(err):
+			log.L.Error(err, "dropped the item rejected permanently", "name", b.name)
+		default:
+			kept, keptErr = append(kept, item), err
+		}
+	}
+	if keptErr == nil && addedErr != nil && !isPermanent(addedErr) {
+		// flush the next items at once as well
+		b.settle(kept, addedErr)
+	} else {
+		b.settle(kept, keptErr)
+	}
+	if added {
+		return addedErr
+	}
+	return keptErr
+}
+
+// settle records the result of the flush, the items of the failed flush are put back before the ones added meanwhile
+func (b *batcher[T]) settle(items []T, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+	if err != nil {
+		b.items = append(items[:len(items):len(items)], b.items...)
+	}
+}
+
+func (b *batcher[T]) run() {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-t.C:
+		}
+		if err := b.Flush(); err != nil {
+			log.L.Error(err, "flush batch failed", "name", b.name)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package output
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBatcher_keepFailed(t *testing.T) {
+	var flushed []int
+	failing := true
+	b := &batcher[int]{name: "test", size: 2, keepFailed: true, flush: func(items []int) error {
+		if failing {
+			return errors.New("database not ready")
+		}
+		flushed = append(flushed, items...)
+		return nil
+	}}
+	b.start()
+
+	// the failed flush keeps 1 and returns the error for 2
+	assert.NoError(t, b.Add(1))
+	assert.Error(t, b.Add(2))
+	assert.Equal(t, []int{1}, b.items)
+
+	// every record is flushed with the kept ones until a flush succeeds
+	assert.Error(t, b.Add(3))
+	assert.Equal(t, []int{1}, b.items)
+
+	failing = false
+	assert.NoError(t, b.Add(4))
+	assert.Equal(t, []int{1, 4}, flushed)
+
+	assert.NoError(t, b.Add(5))
+	assert.Len(t, flushed, 2)
+	assert.NoError(t, b.Close())
+	assert.Equal(t, []int{1, 4, 5}, flushed)
+}
+
+func TestBatchedOutputs_Close(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			_, _ = w.Write([]byte(`{"errors": false}`))
+		}
+	}))
+	defer srv.Close()
+
+	ktconfig := &config.KubeTrackConfiguration{Cluster: "test"}
+	// the batches are never full and the intervals never elapse
+	interval := metav1.Duration{Duration: time.Hour}
+	for _, tc := range []struct {
+		out     Output
+		request string
+	}{
+		{NewWebhookOutput(ktconfig, &config.OutputWebhook{URL: srv.URL + "/hook", BatchSize: 10, BatchInterval: interval}), "POST /hook"},
+		{NewLokiOutput(ktconfig, &config.OutputLoki{URL: srv.URL + "/loki/api/v1/push", BatchInterval: interval}), "POST /loki/api/v1/push"},
+		{NewElasticsearchOutput(ktconfig, &config.OutputElasticsearch{Addresses: []string{srv.URL}, SkipIndexTemplate: true, FlushInterval: interval}), "POST /_bulk"},
+		{NewClickhouseOutput(ktconfig, &config.OutputClickhouse{URL: srv.URL, FlushInterval: interval}), "POST /"},
+		{NewOTLPOutput(ktconfig, &config.OutputOTLP{Protocol: "http", Endpoint: srv.URL, BatchInterval: interval}), "POST /v1/logs"},
+		{NewS3Output(ktconfig, &config.OutputS3{Endpoint: strings.TrimPrefix(srv.URL, "http://"), Insecure: true, PathStyle: true, Region: "us-east-1", Bucket: "kubetrack", FlushInterval: interval}), "PUT"},
+	} {
+		t.Run(tc.out.Name(), func(t *testing.T) {
+			count := func() (n int) {
+				mu.Lock()
+				defer mu.Unlock()
+				for request, c := range requests {
+					if strings.HasPrefix(request, tc.request) {
+						n += c
+					}
+				}
+				return
+			}
+			before := count()
+			require.NoError(t, tc.out.Write(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Kind: "Pod", Name: "nginx"}}))
+			assert.Equal(t, before, count())
+
+			require.NoError(t, CloseOutput(tc.out))
+			assert.Greater(t, count(), before)
+			assert.NoError(t, CloseOutput(tc.out))
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultClickhouseDatabase      = "default"
+	defaultClickhouseTable         = "events"
+	defaultClickhouseFlushSize     = 1000
+	defaultClickhouseFlushInterval = 5 * time.Second
+)
+
+type ClickhouseOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputClickhouse
+	client   *http.Client
+	batcher  *batcher[OutputStruct]
+}
+
+// clickhouseRow is the row of the events table in JSONEachRow format
+type clickhouseRow struct {
+	Cluster   string    `json:"cluster"`
+	EventTime time.Time `json:"event_time"`
+	Source    string    `json:"source"`
+	EventType string    `json:"event_type"`
+
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+
+	Fields  string `json:"fields"`
+	Message string `json:"message"`
+
+	Object    string `json:"object"`
+	Diff      string `json:"diff"`
+	JsonPatch string `json:"json_patch"`
+}
+
+func NewClickhouseOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputClickhouse) *ClickhouseOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.URL == "" {
+		log.L.Error(nil, "clickhouse url not set")
+		os.Exit(1)
+	}
+
+	client, err := newHTTPClient(conf.TLS, conf.Timeout.Duration)
+	if err != nil {
+		log.L.Error(err, "init clickhouse http client failed")
+		os.Exit(1)
+	}
+
+	out := &ClickhouseOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+	out.migrate()
+	out.batcher = newBatcher(out.Name(), cmp.Or(conf.FlushSize, defaultClickhouseFlushSize), cmp.Or(conf.FlushInterval.Duration, defaultClickhouseFlushInterval), out.insert)
+
+	return out
+}
+
+func (co *ClickhouseOutput) Name() string {
+	return "clickhouse"
+}
+
+func (co *ClickhouseOutput) Write(out OutputStruct) error {
+	return co.batcher.Add(out)
+}
+
+// Close inserts the pending records
+func (co *ClickhouseOutput) Close() error {
+	return co.batcher.Close()
+}
+
+func (co *ClickhouseOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	co.batcher.handleFailed(fn)
+	return true
+}
+
+func (co *ClickhouseOutput) tableName() string {
+	return fmt.Sprintf("`%s`.`%s`", cmp.Or(co.conf.Database, defaultClickhouseDatabase), cmp.Or(co.conf.Table, defaultClickhouseTable))
+}
+
+func (co *ClickhouseOutput) migrate() {
+	log.L.Info("migrating clickhouse")
+
+	ddl := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	created_at DateTime64(3) DEFAULT now64(3),
+	cluster LowCardinality(String),
+	event_time DateTime64(3),
+	source LowCardinality(String),
+	event_type LowCardinality(String),
+	api_version LowCardinality(String),
+	kind LowCardinality(String),
+	namespace String,
+	name String,
+	uid String,
+	fields String,
+	message String,
+	object String,
+	diff String,
+	json_patch String
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(event_time)
+ORDER BY (cluster, kind, namespace, name, event_time)`, co.tableName())
+	if err := co.exec(ddl, nil); err != nil {
+		log.L.Error(err, "migrate error")
+		os.Exit(1)
+	}
+
+	// keep the table TTL in sync with the config
+	if err := co.syncTTL(); err != nil {
+		log.L.Error(err, "sync clickhouse table ttl failed")
+		os.Exit(1)
+	}
+}
+
+// syncTTL modifies the table TTL to ttlDays, or removes it when ttlDays is 0
+func (co *ClickhouseOutput) syncTTL() error {
+	if co.conf.TTLDays > 0 {
+		return co.exec(fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDateTime(event_time) + INTERVAL %d DAY", co.tableName(), co.conf.TTLDays), nil)
+	}
+	// REMOVE TTL fails if the table has no TTL
+	body, err := co.query(fmt.Sprintf("SELECT count() FROM system.tables WHERE database = %s AND name = %s AND engine_full LIKE '%% TTL %%' FORMAT TabSeparated",
+		clickhouseString(cmp.Or(co.conf.Database, defaultClickhouseDatabase)), clickhouseString(cmp.Or(co.conf.Table, defaultClickhouseTable))), nil)
+	if err != nil {
+		return errors.Wrap(err, "query clickhouse table ttl failed")
+	}
+	if strings.TrimSpace(string(body)) == "0" {
+		return nil
+	}
+	return co.exec(fmt.Sprintf("ALTER TABLE %s REMOVE TTL", co.tableName()), nil)
+}
+
+// clickhouseString quotes s as a clickhouse string literal
+func clickhouseString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func (co *ClickhouseOutput) insert(outs []OutputStruct) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, out := range outs {
+		row := clickhouseRow{
+			Cluster:   co.ktconfig.Cluster,
+			EventTime: out.EventTime,
+			Source:    string(out.Source),
+			EventType: string(out.EventType),
+
+			APIVersion: out.ObjectRef.APIVersion,
+			Kind:       out.ObjectRef.Kind,
+			Namespace:  out.ObjectRef.Namespace,
+			Name:       out.ObjectRef.Name,
+			UID:        string(out.ObjectRef.UID),
+
+			Fields:  mustMarshalString(out.Fields),
+			Message: out.Message,
+
+			Object:    mustMarshalString(out.Object),
+			Diff:      out.Diff,
+			JsonPatch: out.JsonPatch,
+		}
+		if err := enc.Encode(row); err != nil {
+			return errors.Wrap(err, "encode clickhouse row failed")
+		}
+	}
+
+	return co.exec(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", co.tableName()), buf.Bytes())
+}
+
+// exec executes the query, the data is sent as the request body following the query
+func (co *ClickhouseOutput) exec(query string, data []byte) error {
+	_, err := co.query(query, data)
+	return err
+}
+
+// query runs the query with the data as the input, and returns the response body
+func (co *ClickhouseOutput) query(query string, data []byte) ([]byte, error) {
+	params := url.Values{}
+	params.Set("date_time_input_format", "best_effort")
+	// let the server buffer the inserts, and wait for the flush to know if it succeeded
+	params.Set("async_insert", "1")
+	params.Set("wait_for_async_insert", "1")
+
+	var body []byte
+	if data == nil {
+		body = []byte(query)
+	} else {
+		params.Set("query", query)
+		body = data
+	}
+
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(co.conf.URL, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if co.conf.Username != "" {
+			req.Header.Set("X-ClickHouse-User", co.conf.Username)
+			req.Header.Set("X-ClickHouse-Key", co.conf.Password)
+		}
+		return req, nil
+	}
+
+	maxRetries := defaultHTTPMaxRetries
+	if co.conf.MaxRetries != nil {
+		maxRetries = *co.conf.MaxRetries
+	}
+	return doHTTPWithRetry(co.client, newReq, maxRetries, cmp.Or(co.conf.RetryBackoff.Duration, defaultHTTPRetryBackoff))
+}
+
+func mustMarshalString(v any) string {
+	if v == nil {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
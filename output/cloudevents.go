@@ -0,0 +1,78 @@
+package output
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/utils/goutils"
+	"github.com/pkg/errors"
+)
+
+const (
+	CloudEventsSpecVersion      = "1.0"
+	CloudEventsContentType      = "application/cloudevents+json"
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+	CloudEventsTypePrefix       = "io.kubetrack"
+)
+
+// CloudEvent is the CloudEvents 1.0 envelope of a record, the data is the Document of the record
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Document  `json:"data"`
+}
+
+func NewCloudEvent(cluster string, out OutputStruct) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              cmp.Or(out.IdempotencyKey, goutils.UUID()),
+		Source:          cmp.Or(cluster, "default"),
+		Type:            fmt.Sprintf("%s.%s.%s", CloudEventsTypePrefix, out.Source, out.EventType),
+		Subject:         cloudEventSubject(out),
+		Time:            out.EventTime,
+		DataContentType: "application/json",
+		Data:            NewDocument(cluster, out),
+	}
+}
+
+// Attributes returns the context attributes of the event except data, which are used by the binary mode
+func (ce CloudEvent) Attributes() map[string]string {
+	attrs := map[string]string{
+		"specversion": ce.SpecVersion,
+		"id":          ce.ID,
+		"source":      ce.Source,
+		"type":        ce.Type,
+		"time":        ce.Time.Format(time.RFC3339Nano),
+	}
+	if ce.Subject != "" {
+		attrs["subject"] = ce.Subject
+	}
+	return attrs
+}
+
+// cloudEventSubject is the object reference in the form of apiVersion/kind/namespace/name
+func cloudEventSubject(out OutputStruct) string {
+	parts := []string{out.ObjectRef.APIVersion, out.ObjectRef.Kind}
+	if out.ObjectRef.Namespace != "" {
+		parts = append(parts, out.ObjectRef.Namespace)
+	}
+	parts = append(parts, out.ObjectRef.Name)
+	return strings.Join(parts, "/")
+}
+
+func validateCloudEventsMode(mode config.CloudEventsMode) error {
+	switch mode {
+	case "", config.CloudEventsModeStructured, config.CloudEventsModeBinary:
+		return nil
+	default:
+		return errors.Errorf("unknown cloudevents mode: %s", mode)
+	}
+}
@@ -1,12 +1,29 @@
 package output
 
 import (
+	"cmp"
+	"context"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/major1201/kubetrack/config"
 	"github.com/major1201/kubetrack/gormutils"
+	"github.com/major1201/kubetrack/health"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/metrics"
+	"github.com/pkg/errors"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	corev1 "k8s.io/api/core/v1"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// Events is the row of the events table, the schema is maintained by eventsMigrations
 type Events struct {
 	gormutils.ModelUnscoped
 
@@ -15,16 +32,253 @@ type Events struct {
 	Source    string    `json:"source" gorm:"type:varchar(64);index"`
 	EventType string    `json:"event_type" gorm:"type:varchar(64);index"`
 
-	APIVersion string `json:"api_version" gorm:"type:varchar(64);index"`
+	APIVersion string `json:"api_version" gorm:"type:varchar(255);index"`
 	Kind       string `json:"kind" gorm:"type:varchar(64);index"`
 	Namespace  string `json:"namespace" gorm:"type:varchar(64);index"`
-	Name       string `json:"name" gorm:"type:varchar(64);index"`
+	Name       string `json:"name" gorm:"type:varchar(253);index"`
 	UID        string `json:"uid" gorm:"type:varchar(64);index"`
 
 	Fields  datatypes.JSON `json:"fields"`
 	Message string         `json:"message" gorm:"type:text"`
 
+	// the type of the kubernetes event, Normal or Warning, empty for the other records,
+	// null for the records written before the column, whose type is prefixed to the message
+	Severity string `json:"severity" gorm:"type:varchar(16)"`
+
 	Object    datatypes.JSON `json:"object"`
 	Diff      string         `json:"diff" gorm:"type:text"`
 	JsonPatch datatypes.JSON `json:"json_patch"`
+
+	// null if the record has no idempotency key, nulls are not unique
+	IdempotencyKey *string `json:"idempotency_key" gorm:"type:varchar(64);uniqueIndex"`
+}
+
+func NewEvents(cluster string, out OutputStruct) *Events {
+	var idempotencyKey *string
+	if out.IdempotencyKey != "" {
+		idempotencyKey = &out.IdempotencyKey
+	}
+	var severity string
+	if out.Source == SourceTypeEvent {
+		severity = corev1.EventTypeNormal
+		if out.IsWarning() {
+			severity = corev1.EventTypeWarning
+		}
+	}
+	return &Events{
+		Cluster:   cluster,
+		EventTime: out.EventTime,
+		Source:    string(out.Source),
+		EventType: string(out.EventType),
+
+		APIVersion: out.ObjectRef.APIVersion,
+		Kind:       out.ObjectRef.Kind,
+		Namespace:  out.ObjectRef.Namespace,
+		Name:       out.ObjectRef.Name,
+		UID:        string(out.ObjectRef.UID),
+
+		Fields:  gormutils.MustToJsonb(out.Fields),
+		Message: out.Message,
+
+		Severity: severity,
+
+		Object:    gormutils.MustToJsonb(out.Object),
+		Diff:      out.Diff,
+		JsonPatch: gormutils.MustToJsonb(out.JsonPatch),
+
+		IdempotencyKey: idempotencyKey,
+	}
+}
+
+const (
+	defaultDBFlushSize     = 1
+	defaultDBFlushInterval = time.Second
+)
+
+// newDBBatcher returns nil if flushSize is 1, the records are inserted synchronously then,
+// the records of a failed flush are kept until a flush succeeds
+func newDBBatcher(name string, conn *dbConn, cluster string, flushSize int, flushInterval time.Duration) *batcher[OutputStruct] {
+	flushSize = cmp.Or(flushSize, defaultDBFlushSize)
+	if flushSize <= 1 {
+		return nil
+	}
+	b := &batcher[OutputStruct]{
+		name:     name,
+		size:     flushSize,
+		interval: cmp.Or(flushInterval, defaultDBFlushInterval),
+		flush: func(outs []OutputStruct) error {
+			db, err := conn.DB()
+			if err != nil {
+				return err
+			}
+			return insertEvents(db, cluster, outs...)
+		},
+		keepFailed: true,
+	}
+	b.start()
+	return b
+}
+
+// insertEvents inserts the records with a multi-row insert statement,
+// the records whose idempotency key already exists are ignored.
+// The data and constraint errors are returned as permanentError, the records failing them are never inserted
+func insertEvents(db *gorm.DB, cluster string, outs ...OutputStruct) error {
+	events := make([]*Events, 0, len(outs))
+	for _, out := range outs {
+		events = append(events, NewEvents(cluster, out))
+	}
+	var err error
+	if db.Dialector.Name() == "postgres" {
+		err = insertPostgresEvents(db, events)
+	} else {
+		err = errors.WithStack(db.Clauses(clause.OnConflict{DoNothing: true}).Create(events).Error)
+	}
+	if err != nil && isDataError(err) {
+		return permanentError{err}
+	}
+	return err
+}
+
+// the mysql errors of the data, e.g. data too long, incorrect string value or invalid json
+var mysqlDataErrors = map[uint16]bool{1048: true, 1264: true, 1292: true, 1366: true, 1406: true, 3140: true, 3819: true}
+
+// isDataError tells if the database rejects the data or the constraints fail, not the connection or the server
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// class 22 data exception, class 23 integrity constraint violation
+		return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlDataErrors[mysqlErr.Number]
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT, sqlite3.SQLITE_MISMATCH, sqlite3.SQLITE_TOOBIG:
+			return true
+		}
+	}
+	return false
+}
+
+// openDB opens the database owned by the output and checks the connection
+func openDB(driver, dsn string, pool config.DBPool) (*gorm.DB, error) {
+	opts := gormutils.DBOptionsFromEnv()
+	opts.Driver = driver
+	opts.Connection = dsn
+	opts.MaxOpenConns = cmp.Or(pool.MaxOpenConns, opts.MaxOpenConns)
+	opts.MaxIdleConns = cmp.Or(pool.MaxIdleConns, opts.MaxIdleConns)
+	opts.ConnMaxLifetime = cmp.Or(pool.ConnMaxLifetime.Duration, opts.ConnMaxLifetime)
+	opts.Debug = pool.Debug || opts.Debug
+
+	db, err := gormutils.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	// try db connection
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = sqlDB.Ping(); err != nil {
+		_ = sqlDB.Close()
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
+
+// ErrDBNotReady is returned by the database outputs before the database is connected
+var ErrDBNotReady = errors.New("database not ready")
+
+const (
+	dbConnectMinBackoff = time.Second
+	dbConnectMaxBackoff = time.Minute
+)
+
+// dbConn connects the database of an output in the background, the output starts in a degraded
+// state and keeps retrying with backoff if the database is unreachable on startup
+type dbConn struct {
+	name    string
+	connect func() (*gorm.DB, error)
+
+	mu  sync.RWMutex
+	db  *gorm.DB
+	err error
+}
+
+// newDBConn tries to connect once in the caller goroutine, then keeps retrying in the background if failed,
+// connect should open the database and migrate it
+func newDBConn(name string, connect func() (*gorm.DB, error)) *dbConn {
+	c := &dbConn{connect: connect}
+	c.name = health.Register(name, c.check)
+	registerHoldStore(c)
+	metrics.OutputUp.WithLabelValues(c.name).Set(0)
+
+	if !c.tryConnect() {
+		go c.run()
+	}
+	return c
+}
+
+// DB returns the database, or ErrDBNotReady with the last connection error if it's not connected yet
+func (c *dbConn) DB() (*gorm.DB, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return nil, errors.Wrapf(ErrDBNotReady, "%s: %v", c.name, c.err)
+	}
+	return c.db, nil
+}
+
+func (c *dbConn) run() {
+	backoff := dbConnectMinBackoff
+	for {
+		time.Sleep(backoff)
+		if c.tryConnect() {
+			return
+		}
+		backoff = min(backoff*2, dbConnectMaxBackoff)
+	}
+}
+
+func (c *dbConn) tryConnect() bool {
+	db, err := c.connect()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.err = err
+		log.L.Error(err, "database not ready, output is degraded, reconnecting in the background", "name", c.name)
+		return false
+	}
+	c.db, c.err = db, nil
+	metrics.OutputUp.WithLabelValues(c.name).Set(1)
+	log.L.Info("database connected", "name", c.name)
+	return true
+}
+
+// check reports the database as not ready before connected or when the ping fails
+func (c *dbConn) check(ctx context.Context) error {
+	db, err := c.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		metrics.OutputUp.WithLabelValues(c.name).Set(0)
+		return errors.WithStack(err)
+	}
+	metrics.OutputUp.WithLabelValues(c.name).Set(1)
+	return nil
 }
@@ -0,0 +1,332 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/gormutils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// the indexed columns of the events table created by the first migration
+var eventsIndexedColumns = []string{"cluster", "event_time", "source", "event_type", "api_version", "kind", "namespace", "name", "uid"}
+
+// eventsMigrations are the versioned schema changes of the database outputs, never change an applied migration,
+// add a new one instead
+var eventsMigrations = []gormutils.Migration{
+	{
+		Version: 1,
+		Name:    "create_events",
+		Statements: map[string][]string{
+			"postgres": append([]string{`CREATE TABLE IF NOT EXISTS events (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	cluster varchar(64),
+	event_time timestamptz,
+	source varchar(64),
+	event_type varchar(64),
+	api_version varchar(64),
+	kind varchar(64),
+	namespace varchar(64),
+	name varchar(64),
+	uid varchar(64),
+	fields jsonb,
+	message text,
+	object jsonb,
+	diff text,
+	json_patch jsonb
+)`}, createIndexStatements()...),
+			"mysql": {fmt.Sprintf(`CREATE TABLE IF NOT EXISTS events (
+	id bigint unsigned AUTO_INCREMENT,
+	created_at datetime(3) NULL,
+	updated_at datetime(3) NULL,
+	cluster varchar(64),
+	event_time datetime(3) NULL,
+	source varchar(64),
+	event_type varchar(64),
+	api_version varchar(64),
+	kind varchar(64),
+	namespace varchar(64),
+	name varchar(64),
+	uid varchar(64),
+	fields JSON,
+	message text,
+	object JSON,
+	diff text,
+	json_patch JSON,
+	PRIMARY KEY (id),
+	%s
+)`, strings.Join(mysqlIndexDefinitions(), ",\n\t"))},
+			"sqlite": append([]string{`CREATE TABLE IF NOT EXISTS events (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	cluster varchar(64),
+	event_time datetime,
+	source varchar(64),
+	event_type varchar(64),
+	api_version varchar(64),
+	kind varchar(64),
+	namespace varchar(64),
+	name varchar(64),
+	uid varchar(64),
+	fields JSON,
+	message text,
+	object JSON,
+	diff text,
+	json_patch JSON
+)`}, createIndexStatements()...),
+		},
+		// created by AutoMigrate of the older versions
+		Applied: func(db *gorm.DB) bool {
+			return db.Migrator().HasTable(&Events{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_events_idempotency_key",
+		Statements: map[string][]string{
+			"postgres": {
+				"ALTER TABLE events ADD COLUMN idempotency_key varchar(64)",
+				"CREATE UNIQUE INDEX idx_events_idempotency_key ON events (idempotency_key)",
+			},
+			"mysql": {
+				"ALTER TABLE events ADD COLUMN idempotency_key varchar(64), ADD UNIQUE INDEX idx_events_idempotency_key (idempotency_key)",
+			},
+			"sqlite": {
+				"ALTER TABLE events ADD COLUMN idempotency_key varchar(64)",
+				"CREATE UNIQUE INDEX idx_events_idempotency_key ON events (idempotency_key)",
+			},
+		},
+		Applied: func(db *gorm.DB) bool {
+			return db.Migrator().HasColumn(&Events{}, "idempotency_key")
+		},
+	},
+	{
+		// kubernetes object names are up to 253 characters, sqlite doesn't enforce the length
+		Version: 3,
+		Name:    "widen_events_name_and_api_version",
+		Statements: map[string][]string{
+			"postgres": {"ALTER TABLE events ALTER COLUMN name TYPE varchar(253), ALTER COLUMN api_version TYPE varchar(255)"},
+			"mysql":    {"ALTER TABLE events MODIFY name varchar(253), MODIFY api_version varchar(255)"},
+		},
+	},
+	{
+		// query the careFields with the jsonb operators
+		Version: 4,
+		Name:    "add_events_fields_gin_index",
+		Statements: map[string][]string{
+			"postgres": {"CREATE INDEX IF NOT EXISTS idx_events_fields ON events USING gin (fields)"},
+		},
+	},
+	{
+		// the existing table is kept as events_legacy and dropped by the retention once all its rows expire,
+		// the unique keys of a partitioned table must include the partition key
+		Version: 5,
+		Name:    "partition_events",
+		Statements: map[string][]string{
+			"postgres": append([]string{
+				"ALTER TABLE events RENAME TO events_legacy",
+				"ALTER TABLE events_legacy RENAME CONSTRAINT events_pkey TO events_legacy_pkey",
+				`DO $$
+DECLARE r record;
+BEGIN
+	FOR r IN SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = 'events_legacy' AND indexname LIKE 'idx\_events\_%' LOOP
+		EXECUTE format('ALTER INDEX %I RENAME TO %I', r.indexname, replace(r.indexname, 'idx_events_', 'idx_events_legacy_'));
+	END LOOP;
+END $$`,
+				`CREATE TABLE events (
+	id bigint NOT NULL DEFAULT nextval('events_id_seq'),
+	created_at timestamptz,
+	updated_at timestamptz,
+	cluster varchar(64),
+	event_time timestamptz NOT NULL,
+	source varchar(64),
+	event_type varchar(64),
+	api_version varchar(255),
+	kind varchar(64),
+	namespace varchar(64),
+	name varchar(253),
+	uid varchar(64),
+	idempotency_key varchar(64),
+	fields jsonb,
+	message text,
+	object jsonb,
+	diff text,
+	json_patch jsonb,
+	PRIMARY KEY (id, event_time)
+) PARTITION BY RANGE (event_time)`,
+				"ALTER SEQUENCE events_id_seq OWNED BY events.id",
+				"CREATE TABLE events_default PARTITION OF events DEFAULT",
+				"CREATE UNIQUE INDEX idx_events_idempotency_key ON events (idempotency_key, event_time)",
+				"CREATE INDEX idx_events_fields ON events USING gin (fields)",
+			}, createIndexStatements()...),
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_legal_holds",
+		Statements: map[string][]string{
+			"postgres": {`CREATE TABLE IF NOT EXISTS legal_holds (
+	id varchar(64) PRIMARY KEY,
+	namespace varchar(64),
+	uid varchar(64),
+	from_time timestamptz,
+	to_time timestamptz,
+	reason text,
+	placed_by varchar(255),
+	placed_at timestamptz,
+	released_by varchar(255),
+	released_at timestamptz
+)`},
+			"mysql": {`CREATE TABLE IF NOT EXISTS legal_holds (
+	id varchar(64),
+	namespace varchar(64),
+	uid varchar(64),
+	from_time datetime(3) NULL,
+	to_time datetime(3) NULL,
+	reason text,
+	placed_by varchar(255),
+	placed_at datetime(3) NULL,
+	released_by varchar(255),
+	released_at datetime(3) NULL,
+	PRIMARY KEY (id)
+)`},
+			"sqlite": {`CREATE TABLE IF NOT EXISTS legal_holds (
+	id varchar(64) PRIMARY KEY,
+	namespace varchar(64),
+	uid varchar(64),
+	from_time datetime,
+	to_time datetime,
+	reason text,
+	placed_by varchar(255),
+	placed_at datetime,
+	released_by varchar(255),
+	released_at datetime
+)`},
+		},
+	},
+	{
+		// look up the last records of every object for the retention
+		Version: 7,
+		Name:    "add_events_uid_event_time_index",
+		Statements: map[string][]string{
+			"postgres": {"CREATE INDEX IF NOT EXISTS idx_events_uid_event_time ON events (uid, event_time)"},
+			"mysql":    {"CREATE INDEX idx_events_uid_event_time ON events (uid, event_time)"},
+			"sqlite":   {"CREATE INDEX IF NOT EXISTS idx_events_uid_event_time ON events (uid, event_time)"},
+		},
+	},
+	{
+		// the unique index of the partitioned table includes event_time, which differs when the same record is
+		// emitted again after a restart, the idempotency keys are claimed in the unpartitioned table instead
+		Version: 8,
+		Name:    "create_events_keys",
+		Statements: map[string][]string{
+			"postgres": {
+				`CREATE TABLE IF NOT EXISTS events_keys (
+	idempotency_key varchar(64) PRIMARY KEY,
+	event_time timestamptz NOT NULL
+)`,
+				"CREATE INDEX IF NOT EXISTS idx_events_keys_event_time ON events_keys (event_time)",
+				// the keys of the existing records are claimed by backfillEventsKeys in the background,
+				// from the largest id of every table down
+				`CREATE TABLE IF NOT EXISTS events_keys_backfill (
+	table_name varchar(64) PRIMARY KEY,
+	max_id bigint NOT NULL
+)`,
+				"INSERT INTO events_keys_backfill (table_name, max_id) SELECT 'events', max(id) FROM events HAVING max(id) IS NOT NULL",
+				`DO $$
+BEGIN
+	IF to_regclass('events_legacy') IS NOT NULL THEN
+		INSERT INTO events_keys_backfill (table_name, max_id) SELECT 'events_legacy', max(id) FROM events_legacy HAVING max(id) IS NOT NULL;
+	END IF;
+END $$`,
+			},
+		},
+	},
+	{
+		// tell the Warning events by the column instead of the message, the column of the existing records is
+		// left null and the retention tells them by the message
+		Version: 9,
+		Name:    "add_events_severity",
+		Statements: map[string][]string{
+			"postgres": {
+				"ALTER TABLE events ADD COLUMN severity varchar(16)",
+				`DO $$
+BEGIN
+	IF to_regclass('events_legacy') IS NOT NULL THEN
+		ALTER TABLE events_legacy ADD COLUMN severity varchar(16);
+	END IF;
+END $$`,
+			},
+			"mysql":  {"ALTER TABLE events ADD COLUMN severity varchar(16)"},
+			"sqlite": {"ALTER TABLE events ADD COLUMN severity varchar(16)"},
+		},
+	},
+	{
+		// keepLast looks up the last records of every object in the legacy table too, nothing is written to it
+		Version: 10,
+		Name:    "add_events_legacy_uid_event_time_index",
+		Statements: map[string][]string{
+			"postgres": {`DO $$
+BEGIN
+	IF to_regclass('events_legacy') IS NOT NULL THEN
+		CREATE INDEX IF NOT EXISTS idx_events_legacy_uid_event_time ON events_legacy (uid, event_time);
+	END IF;
+END $$`},
+		},
+	},
+}
+
+func createIndexStatements() (stmts []string) {
+	for _, column := range eventsIndexedColumns {
+		stmts = append(stmts, fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_events_%s ON events (%s)", column, column))
+	}
+	return
+}
+
+func mysqlIndexDefinitions() (defs []string) {
+	for _, column := range eventsIndexedColumns {
+		defs = append(defs, fmt.Sprintf("INDEX idx_events_%s (%s)", column, column))
+	}
+	return
+}
+
+// migrateEvents applies the pending migrations of the events table
+func migrateEvents(db *gorm.DB, dryRun bool, w io.Writer) error {
+	return errors.Wrap(gormutils.Migrate(db, eventsMigrations, dryRun, w), "migrate error")
+}
+
+// MigrateOutputs applies the pending migrations of all the database outputs in the config,
+// the statements are printed to w, and only printed in dry run mode
+func MigrateOutputs(ktconfig *config.KubeTrackConfiguration, dryRun bool, w io.Writer) error {
+	for i, outConfig := range ktconfig.Output {
+		var open func() (*gorm.DB, error)
+		switch {
+		case outConfig.Mysql != nil:
+			open = (&MysqlOutput{ktconfig: ktconfig, conf: outConfig.Mysql}).open
+		case outConfig.Postgres != nil:
+			open = (&PostgresOutput{ktconfig: ktconfig, conf: outConfig.Postgres}).open
+		case outConfig.Sqlite != nil:
+			open = (&SqliteOutput{ktconfig: ktconfig, conf: outConfig.Sqlite}).open
+		default:
+			continue
+		}
+
+		db, err := open()
+		if err != nil {
+			return errors.Wrapf(err, "open the database of output %d failed", i)
+		}
+		fmt.Fprintf(w, "-- output %d: %s\n", i, db.Dialector.Name())
+		err = migrateEvents(db, dryRun, w)
+		closeDB(db)
+		if err != nil {
+			return errors.Wrapf(err, "output %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/gormutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateEvents(t *testing.T) {
+	db := tempSqliteDB(t, false)
+
+	// dry run changes nothing
+	var buf bytes.Buffer
+	require.NoError(t, migrateEvents(db, true, &buf))
+	assert.Contains(t, buf.String(), "CREATE TABLE IF NOT EXISTS events")
+	assert.False(t, db.Migrator().HasTable(&Events{}))
+	assert.False(t, db.Migrator().HasTable(&gormutils.SchemaMigration{}))
+
+	require.NoError(t, migrateEvents(db, false, nil))
+	assert.True(t, db.Migrator().HasColumn(&Events{}, "idempotency_key"))
+
+	var records []gormutils.SchemaMigration
+	require.NoError(t, db.Find(&records).Error)
+	assert.Len(t, records, len(eventsMigrations))
+
+	// nothing pending on the second run
+	buf.Reset()
+	require.NoError(t, migrateEvents(db, false, &buf))
+	assert.Equal(t, "-- the schema is up to date\n", buf.String())
+}
+
+func TestMigrateEvents_legacyAutoMigrate(t *testing.T) {
+	db := tempSqliteDB(t, false)
+
+	// the table created by the older versions, before the idempotency key
+	require.NoError(t, db.Exec("CREATE TABLE events (id integer PRIMARY KEY AUTOINCREMENT, event_time datetime, source varchar(64), name varchar(64), uid varchar(64), message text)").Error)
+	require.NoError(t, db.Exec("INSERT INTO events (source, name, message) VALUES ('event', 'warning', 'Warning BackOff Back-off restarting failed container')").Error)
+
+	var buf bytes.Buffer
+	require.NoError(t, migrateEvents(db, false, &buf))
+	assert.Contains(t, buf.String(), "-- 1 create_events: already in the database, recorded as applied")
+	assert.Contains(t, buf.String(), "ALTER TABLE events ADD COLUMN idempotency_key")
+	assert.True(t, db.Migrator().HasColumn(&Events{}, "idempotency_key"))
+
+	// the severity of the existing events is left null, the retention tells them by their messages
+	var severity *string
+	require.NoError(t, db.Raw("SELECT severity FROM events WHERE name = 'warning'").Row().Scan(&severity))
+	assert.Nil(t, severity)
+	warning := retentionPolicies(&config.KubeTrackConfiguration{Events: config.EventRule{WarningTTLDays: 30}}, 0)[0]
+	var count int64
+	require.NoError(t, db.Model(&Events{}).Where(warning.Cond, warning.Vars...).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
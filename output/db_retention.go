@@ -0,0 +1,320 @@
+package output
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// retentionPolicy keeps the records matching the condition for ttlDays by event_time,
+// a record is only governed by the first policy it matches
+type retentionPolicy struct {
+	Name string
+
+	// 0 to keep the records
+	TTLDays int
+
+	// the where condition, empty to match all the records
+	Cond string
+	Vars []any
+}
+
+// retentionPolicies returns the policies of the event types and the rules, then the events,
+// and the ttlDays of the output for the rest records
+func retentionPolicies(ktconfig *config.KubeTrackConfiguration, ttlDays int) (policies []retentionPolicy) {
+	for _, rule := range ktconfig.Rules {
+		cond, vars := ruleCond(rule)
+		name := fmt.Sprintf("%s/%s", rule.APIVersion, rule.Kind)
+
+		for _, action := range []struct {
+			eventType EventType
+			config.EventAction
+		}{
+			{EventTypeAdd, rule.OnCreate},
+			{EventTypeUpdate, rule.OnUpdate},
+			{EventTypeDelete, rule.OnDelete},
+		} {
+			if action.TTLDays > 0 {
+				policies = append(policies, retentionPolicy{
+					Name:    fmt.Sprintf("%s %s", name, action.eventType),
+					TTLDays: action.TTLDays,
+					Cond:    cond + " AND event_type = ?",
+					Vars:    append(append([]any{}, vars...), string(action.eventType)),
+				})
+			}
+		}
+		if rule.TTLDays > 0 {
+			policies = append(policies, retentionPolicy{Name: name, TTLDays: rule.TTLDays, Cond: cond, Vars: vars})
+		}
+	}
+
+	if ktconfig.Events.WarningTTLDays > 0 {
+		policies = append(policies, retentionPolicy{
+			Name:    "Warning events",
+			TTLDays: ktconfig.Events.WarningTTLDays,
+			// the severity of the records written before the column is null, see migration 9
+			Cond: "source = ? AND (severity = ? OR severity IS NULL AND message LIKE ?)",
+			Vars: []any{string(SourceTypeEvent), corev1.EventTypeWarning, corev1.EventTypeWarning + " %"},
+		})
+	}
+	if ktconfig.Events.TTLDays > 0 {
+		policies = append(policies, retentionPolicy{
+			Name:    "events",
+			TTLDays: ktconfig.Events.TTLDays,
+			Cond:    "source = ?",
+			Vars:    []any{string(SourceTypeEvent)},
+		})
+	}
+
+	return append(policies, retentionPolicy{Name: "default", TTLDays: ttlDays})
+}
+
+// ruleCond returns the condition of the change records of the rule, the label selector is not considered
+func ruleCond(rule config.Rule) (string, []any) {
+	cond := "source = ? AND api_version = ? AND kind = ?"
+	vars := []any{string(SourceTypeGeneral), rule.APIVersion, rule.Kind}
+	if len(rule.Namespaces) > 0 {
+		cond += " AND namespace IN ?"
+		vars = append(vars, rule.Namespaces)
+	}
+
+	// the excluded namespaces are wildcards of '*', the namespace names never contain '%' or '_'
+	var excluded []string
+	for _, ns := range rule.ExcludedNamespaces {
+		if strings.Contains(ns, "*") {
+			cond += " AND namespace NOT LIKE ?"
+			vars = append(vars, strings.ReplaceAll(ns, "*", "%"))
+		} else {
+			excluded = append(excluded, ns)
+		}
+	}
+	if len(excluded) > 0 {
+		cond += " AND namespace NOT IN ?"
+		vars = append(vars, excluded)
+	}
+	return cond, vars
+}
+
+// hasRetention tells if any record expires
+func hasRetention(policies []retentionPolicy) bool {
+	for _, p := range policies {
+		if p.TTLDays > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// liveObjects tells the uids of the objects of the rules in the cluster, and false until they are all known
+var liveObjects struct {
+	sync.RWMutex
+	uids func() ([]string, bool)
+}
+
+// SetLiveObjects sets how keepLast tells the live objects of the cluster, the informers of the rules know them
+func SetLiveObjects(uids func() ([]string, bool)) {
+	liveObjects.Lock()
+	defer liveObjects.Unlock()
+	liveObjects.uids = uids
+}
+
+func listLiveObjects() ([]string, bool) {
+	liveObjects.RLock()
+	defer liveObjects.RUnlock()
+	if liveObjects.uids == nil {
+		return nil, false
+	}
+	return liveObjects.uids()
+}
+
+// retentionExemption tells the records never expire, the held ones and the last ones of the live objects
+// of the rules with keepLast
+type retentionExemption struct {
+	dialect  string
+	cluster  string
+	holds    []LegalHold
+	keepLast []ruleKeepLast
+
+	// the ids of the last records are saved in the kept_events table
+	kept bool
+}
+
+type ruleKeepLast struct {
+	cond string
+	vars []any
+	config.KeepLast
+}
+
+func newRetentionExemption(dialect string, holds []LegalHold, ktconfig *config.KubeTrackConfiguration) *retentionExemption {
+	e := &retentionExemption{dialect: dialect, cluster: ktconfig.Cluster, holds: holds}
+	for _, rule := range ktconfig.Rules {
+		if rule.KeepLast.Records > 0 || rule.KeepLast.Snapshots > 0 {
+			cond, vars := ruleCond(rule)
+			e.keepLast = append(e.keepLast, ruleKeepLast{cond: cond, vars: vars, KeepLast: rule.KeepLast})
+		}
+	}
+	return e
+}
+
+// the change records with the full object, the record without it has a json null
+var snapshotConds = map[string]string{
+	"postgres": "jsonb_typeof(object) = 'object'",
+	"mysql":    "JSON_TYPE(object) = 'OBJECT'",
+	"sqlite":   "json_type(object) = 'object'",
+}
+
+// drops the temporary table left by the last run on the connection, the temporary tables live as long as the connection
+var dropTempTables = map[string]string{
+	"postgres": "DROP TABLE IF EXISTS pg_temp.%s",
+	"mysql":    "DROP TEMPORARY TABLE IF EXISTS %s",
+	"sqlite":   "DROP TABLE IF EXISTS temp.%s",
+}
+
+// inserts the ids selected by the query to kept_events, ignoring the ids kept already
+var insertKeptEvents = map[string]string{
+	"postgres": "INSERT INTO kept_events (id) %s ON CONFLICT DO NOTHING",
+	"mysql":    "INSERT IGNORE INTO kept_events (id) %s",
+	"sqlite":   "INSERT OR IGNORE INTO kept_events (id) %s",
+}
+
+// the live objects inserted in a statement
+const liveObjectsBatch = 1000
+
+// saveKept saves the uids of the live objects in the temporary live_objects table, then looks up the last records
+// in the records of from once for all the policies, and saves their ids in the temporary kept_events table,
+// tx must be on a dedicated connection, see gormutils.WithConn
+func (e *retentionExemption) saveKept(tx *gorm.DB, from string) error {
+	if len(e.keepLast) == 0 {
+		return nil
+	}
+	uids, synced := listLiveObjects()
+	if !synced {
+		return errors.New("the live objects are unknown until the informers are synced")
+	}
+
+	stmts := []clause.Expr{
+		{SQL: fmt.Sprintf(dropTempTables[e.dialect], "kept_events")},
+		{SQL: fmt.Sprintf(dropTempTables[e.dialect], "live_objects")},
+		{SQL: "CREATE TEMPORARY TABLE live_objects (uid varchar(64) PRIMARY KEY)"},
+	}
+	for batch := range slices.Chunk(uids, liveObjectsBatch) {
+		vars := make([]any, len(batch))
+		for i, uid := range batch {
+			vars[i] = uid
+		}
+		stmts = append(stmts, clause.Expr{
+			SQL:  "INSERT INTO live_objects (uid) VALUES " + strings.TrimSuffix(strings.Repeat("(?), ", len(batch)), ", "),
+			Vars: vars,
+		})
+	}
+
+	stmts = append(stmts, clause.Expr{SQL: "CREATE TEMPORARY TABLE kept_events (id bigint PRIMARY KEY)"})
+	// a statement per query, mysql can't refer to a temporary table twice in a statement,
+	// the ids kept by more than one rule are ignored
+	for _, k := range e.keepLast {
+		if k.Records > 0 {
+			stmts = append(stmts, clause.Expr{
+				SQL:  fmt.Sprintf(insertKeptEvents[e.dialect], lastRecordsQuery(from, k.cond, "")),
+				Vars: append(append([]any{}, k.vars...), e.cluster, e.cluster, k.Records),
+			})
+		}
+		if k.Snapshots > 0 {
+			stmts = append(stmts, clause.Expr{
+				SQL:  fmt.Sprintf(insertKeptEvents[e.dialect], lastRecordsQuery(from, k.cond, snapshotConds[e.dialect])),
+				Vars: append(append([]any{}, k.vars...), e.cluster, e.cluster, k.Snapshots),
+			})
+		}
+	}
+	if err := execStatements(tx, stmts); err != nil {
+		return errors.Wrap(err, "save the last records failed")
+	}
+	e.kept = true
+	return nil
+}
+
+// dropKept drops the kept_events and live_objects tables
+func (e *retentionExemption) dropKept(tx *gorm.DB) {
+	if !e.kept {
+		return
+	}
+	for _, table := range []string{"kept_events", "live_objects"} {
+		if err := tx.Exec(fmt.Sprintf(dropTempTables[e.dialect], table)).Error; err != nil {
+			log.L.Error(err, "drop the temporary table failed", "table", table)
+		}
+	}
+	e.kept = false
+}
+
+// cond returns the condition of the exempted records, empty if there are none
+func (e *retentionExemption) cond() (string, []any) {
+	var conds []string
+	var vars []any
+	if held, heldVars := heldCond(e.holds); held != "" {
+		conds, vars = append(conds, held), append(vars, heldVars...)
+	}
+	if e.kept {
+		conds = append(conds, "id IN (SELECT id FROM kept_events)")
+	}
+	return strings.Join(conds, " OR "), vars
+}
+
+// lastRecordsQuery selects the ids of the last ? change records matching cond and the filter of every live object,
+// the objects of the cluster ? are live if the informers know them, whether their delete records are written or
+// expired, the objects of the other clusters ? are live if they have no delete record
+func lastRecordsQuery(from, cond, filter string) string {
+	where := fmt.Sprintf("source = '%s' AND uid <> '' AND (%s)", SourceTypeGeneral, cond)
+	if filter != "" {
+		where += " AND " + filter
+	}
+	live := fmt.Sprintf("(cluster = ? AND EXISTS (SELECT 1 FROM live_objects l WHERE l.uid = e.uid) OR cluster <> ? AND NOT EXISTS "+
+		"(SELECT 1 FROM %s AS d WHERE d.uid = e.uid AND d.source = '%s' AND d.event_type = '%s'))", from, SourceTypeGeneral, EventTypeDelete)
+	return "SELECT id FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY uid ORDER BY event_time DESC, id DESC) AS rn " +
+		"FROM " + from + " AS e WHERE " + where + " AND " + live + ") AS latest WHERE rn <= ?"
+}
+
+// where returns the condition of the records expired before cutoff, which match none of the previous policies
+// and are not exempted
+func (p retentionPolicy) where(previous []retentionPolicy, exempt *retentionExemption, cutoff time.Time) (string, []any) {
+	conds, vars := []string{"event_time < ?"}, []any{cutoff}
+	if p.Cond != "" {
+		conds = append(conds, "("+p.Cond+")")
+		vars = append(vars, p.Vars...)
+	}
+	for _, prev := range previous {
+		if prev.Cond == "" {
+			continue
+		}
+		conds = append(conds, "NOT ("+prev.Cond+")")
+		vars = append(vars, prev.Vars...)
+	}
+	if exempted, exemptedVars := exempt.cond(); exempted != "" {
+		conds = append(conds, "NOT ("+exempted+")")
+		vars = append(vars, exemptedVars...)
+	}
+	return strings.Join(conds, " AND "), vars
+}
+
+// deleteExpiredEvents deletes the expired records of every policy except the exempted ones
+func deleteExpiredEvents(db *gorm.DB, policies []retentionPolicy, exempt *retentionExemption, now time.Time) error {
+	for i, p := range policies {
+		if p.TTLDays <= 0 {
+			continue
+		}
+		where, vars := p.where(policies[:i], exempt, now.AddDate(0, 0, -p.TTLDays))
+		result := db.Where(where, vars...).Delete(&Events{})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "delete expired records of %s failed", p.Name)
+		}
+		log.L.Info("expired records deleted", "policy", p.Name, "ttlDays", p.TTLDays, "count", result.RowsAffected)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/gormutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDeleteExpiredEvents(t *testing.T) {
+	ktconfig := &config.KubeTrackConfiguration{
+		Rules: []config.Rule{
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}},
+				OnUpdate:       config.EventAction{TTLDays: 3},
+			},
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}},
+				TTLDays:        90,
+			},
+		},
+		Events: config.EventRule{TTLDays: 1, WarningTTLDays: 30},
+	}
+	policies := retentionPolicies(ktconfig, 7)
+	require.Len(t, policies, 5)
+	assert.True(t, hasRetention(policies))
+	assert.False(t, hasRetention(retentionPolicies(&config.KubeTrackConfiguration{}, 0)))
+
+	db := tempSqliteDB(t, true)
+
+	now := time.Now()
+	record := func(name string, days int, source SourceType, kind string, eventType EventType, message string) OutputStruct {
+		return OutputStruct{
+			EventTime: now.AddDate(0, 0, -days),
+			ObjectRef: corev1.ObjectReference{APIVersion: "v1", Kind: kind, Name: name},
+			Source:    source,
+			EventType: eventType,
+			Message:   message,
+		}
+	}
+	require.NoError(t, insertEvents(db, "test", []OutputStruct{
+		record("pod-update-expired", 4, SourceTypeGeneral, "Pod", EventTypeUpdate, ""),
+		record("pod-update", 2, SourceTypeGeneral, "Pod", EventTypeUpdate, ""),
+		record("pod-add", 4, SourceTypeGeneral, "Pod", EventTypeAdd, ""),
+		record("pod-add-expired", 8, SourceTypeGeneral, "Pod", EventTypeAdd, ""),
+		record("node", 60, SourceTypeGeneral, "Node", EventTypeUpdate, ""),
+		record("node-expired", 91, SourceTypeGeneral, "Node", EventTypeUpdate, ""),
+		record("warning", 20, SourceTypeEvent, "Pod", EventTypeAdd, "Warning BackOff x3 Back-off restarting failed container"),
+		record("normal-expired", 2, SourceTypeEvent, "Pod", EventTypeAdd, "Normal Pulled Successfully pulled image"),
+		record("kubetrack", 6, SourceTypeKubetrack, "Pod", EventTypeAdd, ""),
+	}...))
+
+	require.NoError(t, deleteExpiredEvents(db, policies, &retentionExemption{}, now))
+
+	var names []string
+	require.NoError(t, db.Model(&Events{}).Order("id").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"pod-update", "pod-add", "node", "warning", "kubetrack"}, names)
+}
+
+func TestDeleteExpiredEvents_namespaces(t *testing.T) {
+	pod := metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	ktconfig := &config.KubeTrackConfiguration{
+		Rules: []config.Rule{
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: pod, ExcludedNamespaces: []string{"prod*", "kube-system"}},
+				TTLDays:        3,
+			},
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: pod, Namespaces: []string{"prod"}},
+				TTLDays:        30,
+			},
+		},
+	}
+	policies := retentionPolicies(ktconfig, 14)
+
+	db := tempSqliteDB(t, true)
+
+	now := time.Now()
+	record := func(name, namespace string, days int) OutputStruct {
+		return OutputStruct{
+			EventTime: now.AddDate(0, 0, -days),
+			ObjectRef: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: name},
+			Source:    SourceTypeGeneral,
+			EventType: EventTypeUpdate,
+		}
+	}
+	require.NoError(t, insertEvents(db, "test", []OutputStruct{
+		record("dev", "dev", 2),
+		record("dev-expired", "dev", 4),
+		record("prod", "prod", 10),
+		record("prod-expired", "prod", 31),
+		record("prod-2", "prod-2", 10),
+		record("kube-system", "kube-system", 10),
+		record("kube-system-expired", "kube-system", 15),
+	}...))
+
+	require.NoError(t, deleteExpiredEvents(db, policies, &retentionExemption{}, now))
+
+	var names []string
+	require.NoError(t, db.Model(&Events{}).Order("id").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"dev", "prod", "prod-2", "kube-system"}, names)
+}
+
+func TestDeleteExpiredEvents_keepLast(t *testing.T) {
+	db := tempSqliteDB(t, true)
+
+	now := time.Now()
+	record := func(name, uid string, days int, apiVersion, kind string, eventType EventType, object map[string]any) OutputStruct {
+		return OutputStruct{
+			EventTime: now.AddDate(0, 0, -days),
+			ObjectRef: corev1.ObjectReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(uid)},
+			Source:    SourceTypeGeneral,
+			EventType: eventType,
+			Object:    object,
+		}
+	}
+	binding := func(name, uid string, days int, object map[string]any) OutputStruct {
+		return record(name, uid, days, "rbac.authorization.k8s.io/v1", "ClusterRoleBinding", EventTypeUpdate, object)
+	}
+	snapshot := map[string]any{"kind": "ClusterRoleBinding"}
+	require.NoError(t, insertEvents(db, "test", []OutputStruct{
+		binding("a-snapshot", "uid-a", 200, snapshot),
+		binding("a-1", "uid-a", 190, nil),
+		binding("a-2", "uid-a", 180, nil),
+		binding("a-3", "uid-a", 170, nil),
+		binding("b-1", "uid-b", 180, nil),
+		binding("no-uid", "", 180, nil),
+		// the records of the deleted pod expire as usual
+		record("pod-add", "uid-pod", 100, "v1", "Pod", EventTypeAdd, nil),
+		record("pod-delete", "uid-pod", 90, "v1", "Pod", EventTypeDelete, nil),
+		record("live-pod-add", "uid-live-pod", 100, "v1", "Pod", EventTypeAdd, nil),
+		// the records of the kinds without keepLast are not kept
+		record("node", "uid-node", 100, "v1", "Node", EventTypeUpdate, nil),
+	}...))
+	// the event records of the object are not counted
+	require.NoError(t, insertEvents(db, "test", OutputStruct{
+		EventTime: now,
+		ObjectRef: corev1.ObjectReference{UID: "uid-a", Name: "a-event"},
+		Source:    SourceTypeEvent,
+		EventType: EventTypeAdd,
+	}))
+
+	setLiveObjects(t, "uid-a", "uid-b", "uid-live-pod", "uid-node")
+	ktconfig := &config.KubeTrackConfiguration{
+		Cluster: "test",
+		Rules: []config.Rule{
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"}},
+				KeepLast:       config.KeepLast{Records: 2, Snapshots: 1},
+			},
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}},
+				KeepLast:       config.KeepLast{Records: 1},
+			},
+			{
+				ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}},
+			},
+		},
+	}
+	exempt := newRetentionExemption(db.Dialector.Name(), nil, ktconfig)
+	require.NoError(t, gormutils.WithConn(db, func(tx *gorm.DB) error {
+		if err := exempt.saveKept(tx, "events"); err != nil {
+			return err
+		}
+		defer exempt.dropKept(tx)
+		return deleteExpiredEvents(tx, retentionPolicies(ktconfig, 30), exempt, now)
+	}))
+
+	var names []string
+	require.NoError(t, db.Model(&Events{}).Order("id").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"a-snapshot", "a-2", "a-3", "b-1", "live-pod-add", "a-event"}, names)
+}
+
+func TestDeleteExpiredEvents_keepLastDeleted(t *testing.T) {
+	db := tempSqliteDB(t, true)
+
+	now := time.Now()
+	record := func(cluster, uid string, days int, eventType EventType) {
+		require.NoError(t, insertEvents(db, cluster, OutputStruct{
+			EventTime: now.AddDate(0, 0, -days),
+			ObjectRef: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: uid, UID: types.UID(uid)},
+			Source:    SourceTypeGeneral,
+			EventType: eventType,
+		}))
+	}
+	// the delete record expires before the other records
+	record("test", "deleted", 20, EventTypeAdd)
+	record("test", "deleted", 2, EventTypeDelete)
+	// deleted while kubetrack was down, or its delete record was filtered out
+	record("test", "missed", 20, EventTypeAdd)
+	record("test", "live", 20, EventTypeAdd)
+	// the liveness of the other clusters is told by the delete records
+	record("other", "other-deleted", 20, EventTypeAdd)
+	record("other", "other-deleted", 0, EventTypeDelete)
+	record("other", "other-live", 20, EventTypeAdd)
+
+	ktconfig := &config.KubeTrackConfiguration{
+		Cluster: "test",
+		Rules: []config.Rule{{
+			ObjectSelector: config.ObjectSelector{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}},
+			OnDelete:       config.EventAction{TTLDays: 1},
+			KeepLast:       config.KeepLast{Records: 1},
+		}},
+	}
+	cleanup := func(now time.Time) []string {
+		exempt := newRetentionExemption(db.Dialector.Name(), nil, ktconfig)
+		require.NoError(t, gormutils.WithConn(db, func(tx *gorm.DB) error {
+			if err := exempt.saveKept(tx, "events"); err != nil {
+				return err
+			}
+			defer exempt.dropKept(tx)
+			return deleteExpiredEvents(tx, retentionPolicies(ktconfig, 30), exempt, now)
+		}))
+		var names []string
+		require.NoError(t, db.Model(&Events{}).Order("id").Pluck("name", &names).Error)
+		return names
+	}
+
+	// nothing is deleted until the live objects are known
+	exempt := newRetentionExemption(db.Dialector.Name(), nil, ktconfig)
+	require.Error(t, gormutils.WithConn(db, func(tx *gorm.DB) error { return exempt.saveKept(tx, "events") }))
+
+	setLiveObjects(t, "live")
+	assert.Equal(t, []string{"deleted", "missed", "live", "other-deleted", "other-deleted", "other-live"}, cleanup(now))
+	assert.Equal(t, []string{"live", "other-live"}, cleanup(now.AddDate(0, 0, 15)))
+}
+
+// setLiveObjects sets the live objects of the cluster until the test finishes
+func setLiveObjects(t *testing.T, uids ...string) {
+	SetLiveObjects(func() ([]string, bool) { return uids, true })
+	t.Cleanup(func() { SetLiveObjects(nil) })
+}
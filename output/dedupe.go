@@ -0,0 +1,87 @@
+package output
+
+import (
+	"cmp"
+	"container/list"
+	"sync"
+
+	"github.com/major1201/kubetrack/config"
+)
+
+const defaultDedupeSize = 10000
+
+// DedupedOutput drops the records whose idempotency key is among the recently seen ones,
+// the keys are kept in a bounded LRU cache
+type DedupedOutput struct {
+	output Output
+	size   int
+
+	mu    sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List // the most recently seen key is at the front
+}
+
+func NewDedupedOutput(out Output, conf *config.OutputDedupe) *DedupedOutput {
+	if conf == nil {
+		conf = &config.OutputDedupe{}
+	}
+	return &DedupedOutput{
+		output: out,
+		size:   cmp.Or(conf.Size, defaultDedupeSize),
+		keys:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+func (do *DedupedOutput) Name() string {
+	return do.output.Name()
+}
+
+// Write writes the record unless its key is seen, the key is remembered once the record is written,
+// so a record dropped or failed by the wrapped output is not suppressed when it's written again
+func (do *DedupedOutput) Write(out OutputStruct) error {
+	if out.IdempotencyKey == "" {
+		return do.output.Write(out)
+	}
+	if do.seen(out.IdempotencyKey) {
+		return nil
+	}
+	if err := do.output.Write(out); err != nil {
+		return err
+	}
+	do.remember(out.IdempotencyKey)
+	return nil
+}
+
+func (do *DedupedOutput) Close() error {
+	return CloseOutput(do.output)
+}
+
+// seen tells if the key is seen recently
+func (do *DedupedOutput) seen(key string) bool {
+	do.mu.Lock()
+	defer do.mu.Unlock()
+
+	if elem, ok := do.keys[key]; ok {
+		do.order.MoveToFront(elem)
+		return true
+	}
+	return false
+}
+
+// remember remembers the key as the most recently seen one
+func (do *DedupedOutput) remember(key string) {
+	do.mu.Lock()
+	defer do.mu.Unlock()
+
+	if elem, ok := do.keys[key]; ok {
+		do.order.MoveToFront(elem)
+		return
+	}
+	do.keys[key] = do.order.PushFront(key)
+	for do.order.Len() > do.size {
+		oldest := do.order.Back()
+		do.order.Remove(oldest)
+		delete(do.keys, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,67 @@
+package output
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type recordingOutput struct {
+	mu      sync.Mutex
+	written []string
+}
+
+func (ro *recordingOutput) Name() string {
+	return "recording"
+}
+
+func (ro *recordingOutput) Write(out OutputStruct) error {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	ro.written = append(ro.written, out.ObjectRef.Name)
+	return nil
+}
+
+func (ro *recordingOutput) Len() int {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	return len(ro.written)
+}
+
+func TestDedupedOutput(t *testing.T) {
+	record := func(name, key string) OutputStruct {
+		return OutputStruct{ObjectRef: corev1.ObjectReference{Name: name}, IdempotencyKey: key}
+	}
+	ro := &recordingOutput{}
+	do := NewDedupedOutput(ro, &config.OutputDedupe{Size: 2})
+
+	for _, out := range []OutputStruct{
+		record("a", "1"),
+		record("a-dup", "1"),
+		record("b", "2"),
+		record("no-key", ""),
+		record("no-key", ""),
+		record("c", "3"), // evicts 1
+		record("a-again", "1"),
+		record("c-dup", "3"),
+	} {
+		assert.NoError(t, do.Write(out))
+	}
+	assert.Equal(t, []string{"a", "b", "no-key", "no-key", "c", "a-again"}, ro.written)
+}
+
+func TestDedupedOutput_failed(t *testing.T) {
+	out := OutputStruct{ObjectRef: corev1.ObjectReference{Name: "a"}, IdempotencyKey: "1"}
+	fo := &flakyOutput{down: true}
+	do := NewDedupedOutput(fo, nil)
+
+	// the failed record is written again when it's retried
+	assert.Error(t, do.Write(out))
+	fo.SetDown(false)
+	assert.NoError(t, do.Write(out))
+	assert.NoError(t, do.Write(out))
+	assert.Equal(t, []string{"a"}, fo.Written())
+}
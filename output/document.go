@@ -0,0 +1,51 @@
+package output
+
+import "time"
+
+// Document is the flat form of OutputStruct, which shares the same columns with Events,
+// it's used by the outputs storing records as documents
+type Document struct {
+	Cluster   string    `json:"cluster"`
+	EventTime time.Time `json:"event_time"`
+	Source    string    `json:"source"`
+	EventType string    `json:"event_type"`
+
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+
+	Fields  map[string]any `json:"fields,omitempty"`
+	Message string         `json:"message,omitempty"`
+
+	Object    map[string]any `json:"object,omitempty"`
+	Diff      string         `json:"diff,omitempty"`
+	JsonPatch string         `json:"json_patch,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func NewDocument(cluster string, out OutputStruct) Document {
+	return Document{
+		Cluster:   cluster,
+		EventTime: out.EventTime,
+		Source:    string(out.Source),
+		EventType: string(out.EventType),
+
+		APIVersion: out.ObjectRef.APIVersion,
+		Kind:       out.ObjectRef.Kind,
+		Namespace:  out.ObjectRef.Namespace,
+		Name:       out.ObjectRef.Name,
+		UID:        string(out.ObjectRef.UID),
+
+		Fields:  out.Fields,
+		Message: out.Message,
+
+		Object:    out.Object,
+		Diff:      out.Diff,
+		JsonPatch: out.JsonPatch,
+
+		IdempotencyKey: out.IdempotencyKey,
+	}
+}
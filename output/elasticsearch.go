@@ -0,0 +1,225 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultElasticsearchIndex         = "kubetrack"
+	defaultElasticsearchFlushSize     = 500
+	defaultElasticsearchFlushInterval = 5 * time.Second
+)
+
+type ElasticsearchOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputElasticsearch
+	client   *http.Client
+	batcher  *batcher[OutputStruct]
+
+	addrIndex atomic.Uint64
+}
+
+func NewElasticsearchOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputElasticsearch) *ElasticsearchOutput {
+	if conf == nil {
+		return nil
+	}
+	if len(conf.Addresses) == 0 {
+		log.L.Error(nil, "elasticsearch addresses not set")
+		os.Exit(1)
+	}
+
+	client, err := newHTTPClient(conf.TLS, conf.Timeout.Duration)
+	if err != nil {
+		log.L.Error(err, "init elasticsearch http client failed")
+		os.Exit(1)
+	}
+
+	out := &ElasticsearchOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+	if !conf.SkipIndexTemplate {
+		if err := out.putIndexTemplate(); err != nil {
+			log.L.Error(err, "put elasticsearch index template failed")
+			os.Exit(1)
+		}
+	}
+	out.batcher = newBatcher(out.Name(), cmp.Or(conf.FlushSize, defaultElasticsearchFlushSize), cmp.Or(conf.FlushInterval.Duration, defaultElasticsearchFlushInterval), out.bulk)
+
+	return out
+}
+
+func (eo *ElasticsearchOutput) Name() string {
+	return "elasticsearch"
+}
+
+func (eo *ElasticsearchOutput) Write(out OutputStruct) error {
+	return eo.batcher.Add(out)
+}
+
+// Close indexes the pending records
+func (eo *ElasticsearchOutput) Close() error {
+	return eo.batcher.Close()
+}
+
+func (eo *ElasticsearchOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	eo.batcher.handleFailed(fn)
+	return true
+}
+
+func (eo *ElasticsearchOutput) indexPrefix() string {
+	return cmp.Or(eo.conf.Index, defaultElasticsearchIndex)
+}
+
+func (eo *ElasticsearchOutput) indexName(eventTime time.Time) string {
+	switch eo.conf.IndexRotation {
+	case config.IndexRotationNone:
+		return eo.indexPrefix()
+	case config.IndexRotationMonthly:
+		return eo.indexPrefix() + "-" + eventTime.UTC().Format("2006.01")
+	default:
+		return eo.indexPrefix() + "-" + eventTime.UTC().Format("2006.01.02")
+	}
+}
+
+func (eo *ElasticsearchOutput) bulk(outs []OutputStruct) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, out := range outs {
+		meta := map[string]any{"_index": eo.indexName(out.EventTime)}
+		if out.IdempotencyKey != "" {
+			// the same record overwrites the document instead of duplicating it
+			meta["_id"] = out.IdempotencyKey
+		}
+		action := map[string]any{"index": meta}
+		if err := enc.Encode(action); err != nil {
+			return errors.Wrap(err, "encode bulk action failed")
+		}
+		if err := enc.Encode(NewDocument(eo.ktconfig.Cluster, out)); err != nil {
+			return errors.Wrap(err, "encode bulk document failed")
+		}
+	}
+
+	body, err := eo.do(http.MethodPost, "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return errors.Wrap(err, "unmarshal bulk response failed")
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	var (
+		failed   int
+		firstErr string
+	)
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed++
+				if firstErr == "" {
+					firstErr = string(result.Error)
+				}
+			}
+		}
+	}
+	return errors.Errorf("bulk index partially failed, failed=%d, total=%d, first error: %s", failed, len(outs), firstErr)
+}
+
+// putIndexTemplate installs the index template, strings in fields are indexed as both text and keyword,
+// the full object is kept in _source only to avoid the mapping explosion among different kinds
+func (eo *ElasticsearchOutput) putIndexTemplate() error {
+	keyword := map[string]any{"type": "keyword"}
+	template := map[string]any{
+		"index_patterns": []string{eo.indexPrefix() + "*"},
+		"template": map[string]any{
+			"mappings": map[string]any{
+				"dynamic_templates": []any{
+					map[string]any{
+						"fields_as_text": map[string]any{
+							"path_match":         "fields.*",
+							"match_mapping_type": "string",
+							"mapping": map[string]any{
+								"type":   "text",
+								"fields": map[string]any{"keyword": map[string]any{"type": "keyword", "ignore_above": 1024}},
+							},
+						},
+					},
+				},
+				"properties": map[string]any{
+					"cluster":     keyword,
+					"event_time":  map[string]any{"type": "date"},
+					"source":      keyword,
+					"event_type":  keyword,
+					"api_version": keyword,
+					"kind":        keyword,
+					"namespace":   keyword,
+					"name":        keyword,
+					"uid":         keyword,
+					"fields":      map[string]any{"type": "object"},
+					"message":     map[string]any{"type": "text"},
+					"object":      map[string]any{"type": "object", "enabled": false},
+					"diff":        map[string]any{"type": "text"},
+					"json_patch":  map[string]any{"type": "text", "index": false},
+
+					"idempotency_key": keyword,
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return errors.Wrap(err, "marshal index template failed")
+	}
+	_, err = eo.do(http.MethodPut, "/_index_template/"+eo.indexPrefix(), "application/json", body)
+	return err
+}
+
+func (eo *ElasticsearchOutput) do(method, path, contentType string, body []byte) ([]byte, error) {
+	newReq := func() (*http.Request, error) {
+		// round robin among the addresses
+		addr := eo.conf.Addresses[eo.addrIndex.Add(1)%uint64(len(eo.conf.Addresses))]
+		req, err := http.NewRequest(method, fmt.Sprintf("%s%s", strings.TrimSuffix(addr, "/"), path), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		switch {
+		case eo.conf.APIKey != "":
+			req.Header.Set("Authorization", "ApiKey "+eo.conf.APIKey)
+		case eo.conf.Username != "":
+			req.SetBasicAuth(eo.conf.Username, eo.conf.Password)
+		}
+		return req, nil
+	}
+
+	maxRetries := defaultHTTPMaxRetries
+	if eo.conf.MaxRetries != nil {
+		maxRetries = *eo.conf.MaxRetries
+	}
+	return doHTTPWithRetry(eo.client, newReq, maxRetries, cmp.Or(eo.conf.RetryBackoff.Duration, defaultHTTPRetryBackoff))
+}
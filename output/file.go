@@ -0,0 +1,72 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type FileOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputFile
+	logger   *lumberjack.Logger
+}
+
+func NewFileOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputFile) *FileOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.Path == "" {
+		log.L.Error(nil, "file output path not set")
+		os.Exit(1)
+	}
+
+	out := &FileOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		logger: &lumberjack.Logger{
+			Filename:   conf.Path,
+			MaxSize:    conf.MaxSizeMB,
+			MaxAge:     conf.MaxAgeDays,
+			MaxBackups: conf.MaxBackups,
+			Compress:   conf.Compress,
+		},
+	}
+	if conf.RotateInterval.Duration > 0 {
+		go out.rotatePeriodically()
+	}
+	log.L.Info("file output initialized", "path", conf.Path)
+
+	return out
+}
+
+func (fo *FileOutput) Name() string {
+	return "file"
+}
+
+func (fo *FileOutput) Write(out OutputStruct) error {
+	line, err := json.Marshal(NewDocument(fo.ktconfig.Cluster, out))
+	if err != nil {
+		return errors.Wrap(err, "marshal document failed")
+	}
+
+	// write the line at once, the logger is goroutine safe
+	_, err = fo.logger.Write(append(line, '\n'))
+	return errors.WithStack(err)
+}
+
+func (fo *FileOutput) rotatePeriodically() {
+	t := time.NewTicker(fo.conf.RotateInterval.Duration)
+	defer t.Stop()
+
+	for range t.C {
+		if err := fo.logger.Rotate(); err != nil {
+			log.L.Error(err, "rotate file failed", "path", fo.conf.Path)
+		}
+	}
+}
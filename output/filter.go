@@ -0,0 +1,100 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/utils/goutils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// FilteredOutput only writes the records matching the filter to the wrapped output
+type FilteredOutput struct {
+	output   Output
+	conf     *config.OutputFilter
+	selector labels.Selector
+}
+
+func NewFilteredOutput(out Output, conf *config.OutputFilter) *FilteredOutput {
+	if conf == nil {
+		return nil
+	}
+	fo := &FilteredOutput{
+		output: out,
+		conf:   conf,
+	}
+	if conf.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(conf.Selector)
+		if err != nil {
+			log.L.Error(err, "invalid output filter selector", "output", out.Name())
+			os.Exit(1)
+		}
+		fo.selector = selector
+	}
+	return fo
+}
+
+func (fo *FilteredOutput) Name() string {
+	return fo.output.Name()
+}
+
+func (fo *FilteredOutput) Write(out OutputStruct) error {
+	if !fo.Match(out) {
+		return nil
+	}
+	return fo.output.Write(out)
+}
+
+func (fo *FilteredOutput) Close() error {
+	return CloseOutput(fo.output)
+}
+
+// Match tells if the record matches all the conditions of the filter
+func (fo *FilteredOutput) Match(out OutputStruct) bool {
+	conf := fo.conf
+
+	if len(conf.Sources) > 0 && !slices.Contains(conf.Sources, string(out.Source)) {
+		return false
+	}
+	if len(conf.EventTypes) > 0 && !slices.Contains(conf.EventTypes, string(out.EventType)) {
+		return false
+	}
+	if conf.WarningOnly && !out.IsWarning() {
+		return false
+	}
+	if len(conf.APIVersions) > 0 && !matchAnyWildcard(conf.APIVersions, out.ObjectRef.APIVersion) {
+		return false
+	}
+	if len(conf.Kinds) > 0 && !matchAnyWildcard(conf.Kinds, out.ObjectRef.Kind) {
+		return false
+	}
+	if len(conf.Namespaces) > 0 && !matchAnyWildcard(conf.Namespaces, out.ObjectRef.Namespace) {
+		return false
+	}
+	if matchAnyWildcard(conf.ExcludedNamespaces, out.ObjectRef.Namespace) {
+		return false
+	}
+	if fo.selector != nil && !fo.selector.Empty() && !fo.selector.Matches(labels.Set(out.Labels)) {
+		return false
+	}
+	for name, patterns := range conf.Fields {
+		value, ok := out.Fields[name]
+		if !ok || !matchAnyWildcard(patterns, fmt.Sprint(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAnyWildcard(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if goutils.WildcardMatchSimple(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilteredOutput_Match(t *testing.T) {
+	deploy := OutputStruct{
+		ObjectRef: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod-web", Name: "nginx"},
+		EventType: EventTypeUpdate,
+		Source:    SourceTypeGeneral,
+		Fields:    map[string]any{"image": "nginx:1.25", "replicas": int64(3)},
+		Labels:    map[string]string{"app": "nginx", "tier": "frontend"},
+	}
+	warning := OutputStruct{
+		ObjectRef: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "prod-web", Name: "nginx-abc"},
+		EventType: EventTypeAdd,
+		Source:    SourceTypeEvent,
+		Object:    map[string]any{"type": "Warning"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   config.OutputFilter
+		expected [2]bool // deploy, warning
+	}{
+		{"empty", config.OutputFilter{}, [2]bool{true, true}},
+		{"sources", config.OutputFilter{Sources: []string{"event"}}, [2]bool{false, true}},
+		{"eventTypes", config.OutputFilter{EventTypes: []string{"update", "delete"}}, [2]bool{true, false}},
+		{"warningOnly", config.OutputFilter{WarningOnly: true}, [2]bool{false, true}},
+		{"production deployments", config.OutputFilter{
+			APIVersions: []string{"apps/*"},
+			Kinds:       []string{"Deployment"},
+			Namespaces:  []string{"prod-*"},
+		}, [2]bool{true, false}},
+		{"excludedNamespaces", config.OutputFilter{ExcludedNamespaces: []string{"prod-*"}}, [2]bool{false, false}},
+		{"selector", config.OutputFilter{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}}, [2]bool{true, false}},
+		{"fields", config.OutputFilter{Fields: map[string][]string{"image": {"nginx:1.*"}, "replicas": {"3"}}}, [2]bool{true, false}},
+		{"fields mismatch", config.OutputFilter{Fields: map[string][]string{"image": {"redis:*"}}}, [2]bool{false, false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fo := NewFilteredOutput(nil, &tc.filter)
+			assert.Equal(t, tc.expected[0], fo.Match(deploy))
+			assert.Equal(t, tc.expected[1], fo.Match(warning))
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package output
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/utils/goutils"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// LegalHold exempts the records matching all its conditions from the retention of the database outputs,
+// the released holds are kept as the audit records
+type LegalHold struct {
+	ID        string     `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Namespace string     `json:"namespace,omitempty" gorm:"type:varchar(64)"`
+	UID       string     `json:"uid,omitempty" gorm:"type:varchar(64)"`
+	From      *time.Time `json:"from,omitempty" gorm:"column:from_time"`
+	To        *time.Time `json:"to,omitempty" gorm:"column:to_time"`
+	Reason    string     `json:"reason" gorm:"type:text"`
+
+	PlacedBy   string     `json:"placedBy" gorm:"type:varchar(255)"`
+	PlacedAt   time.Time  `json:"placedAt"`
+	ReleasedBy string     `json:"releasedBy,omitempty" gorm:"type:varchar(255)"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+}
+
+func (LegalHold) TableName() string {
+	return "legal_holds"
+}
+
+func (h LegalHold) validate() error {
+	if h.Namespace == "" && h.UID == "" && h.From == nil && h.To == nil {
+		return errors.New("at least one of namespace, uid, from and to is required")
+	}
+	if h.From != nil && h.To != nil && !h.From.Before(*h.To) {
+		return errors.New("from must be before to")
+	}
+	return nil
+}
+
+// cond returns the condition of the held records
+func (h LegalHold) cond() (string, []any) {
+	var conds []string
+	var vars []any
+	if h.Namespace != "" {
+		conds, vars = append(conds, "namespace = ?"), append(vars, h.Namespace)
+	}
+	if h.UID != "" {
+		conds, vars = append(conds, "uid = ?"), append(vars, h.UID)
+	}
+	if h.From != nil {
+		conds, vars = append(conds, "event_time >= ?"), append(vars, *h.From)
+	}
+	if h.To != nil {
+		conds, vars = append(conds, "event_time < ?"), append(vars, *h.To)
+	}
+	return strings.Join(conds, " AND "), vars
+}
+
+// heldCond returns the condition of the records held by any of the holds, empty if there are no holds
+func heldCond(holds []LegalHold) (string, []any) {
+	var conds []string
+	var vars []any
+	for _, h := range holds {
+		cond, v := h.cond()
+		conds, vars = append(conds, "("+cond+")"), append(vars, v...)
+	}
+	return strings.Join(conds, " OR "), vars
+}
+
+// configHolds returns the holds in the config, the invalid ones are skipped
+func configHolds(ktconfig *config.KubeTrackConfiguration) (holds []LegalHold) {
+	for i, ch := range ktconfig.Holds {
+		h := LegalHold{
+			ID:        fmt.Sprintf("config-%d", i),
+			Namespace: ch.Namespace,
+			UID:       ch.UID,
+			Reason:    ch.Reason,
+			PlacedBy:  "config",
+		}
+		if ch.From != nil {
+			h.From = &ch.From.Time
+		}
+		if ch.To != nil {
+			h.To = &ch.To.Time
+		}
+		if err := h.validate(); err != nil {
+			log.L.Error(err, "invalid legal hold in the config, skipped", "index", i)
+			continue
+		}
+		holds = append(holds, h)
+	}
+	return
+}
+
+// syncHolds copies the holds placed on or released from the other database outputs while db was degraded to db,
+// and returns the holds in the config and the ones not released yet. The holds are unknown if any of the outputs
+// is not connected, since it may have the holds placed while db was degraded
+func syncHolds(db *gorm.DB, ktconfig *config.KubeTrackConfiguration) ([]LegalHold, error) {
+	var own []LegalHold
+	if err := db.Find(&own).Error; err != nil {
+		return nil, errors.Wrap(err, "list legal holds failed")
+	}
+	// a hold is released if it's released from any of the outputs
+	all := map[string]LegalHold{}
+	merge := func(holds []LegalHold) {
+		for _, h := range holds {
+			if prev, ok := all[h.ID]; !ok || prev.ReleasedAt == nil {
+				all[h.ID] = h
+			}
+		}
+	}
+	merge(own)
+	failed := eachHoldStore(func(name string, storeDB *gorm.DB) error {
+		var holds []LegalHold
+		if err := storeDB.Find(&holds).Error; err != nil {
+			return err
+		}
+		merge(holds)
+		return nil
+	})
+	if len(failed) > 0 {
+		return nil, errors.Errorf("list legal holds of the other outputs failed: %v", failed)
+	}
+
+	owned := map[string]LegalHold{}
+	for _, h := range own {
+		owned[h.ID] = h
+	}
+	holds := configHolds(ktconfig)
+	for id, h := range all {
+		o, ok := owned[id]
+		switch {
+		case !ok:
+			if err := db.Create(&h).Error; err != nil {
+				return nil, errors.Wrapf(err, "copy legal hold %s failed", id)
+			}
+			log.L.Info("legal hold copied from the other outputs", "id", id)
+		case o.ReleasedAt == nil && h.ReleasedAt != nil:
+			if err := db.Model(&LegalHold{}).Where("id = ? AND released_at IS NULL", id).
+				Updates(map[string]any{"released_by": h.ReleasedBy, "released_at": *h.ReleasedAt}).Error; err != nil {
+				return nil, errors.Wrapf(err, "release legal hold %s failed", id)
+			}
+			log.L.Info("legal hold released as on the other outputs", "id", id)
+		}
+		if h.ReleasedAt == nil {
+			holds = append(holds, h)
+		}
+	}
+	return holds, nil
+}
+
+// the database outputs the holds are placed on
+var holdStores struct {
+	sync.Mutex
+	conns []*dbConn
+}
+
+func registerHoldStore(conn *dbConn) {
+	holdStores.Lock()
+	defer holdStores.Unlock()
+	holdStores.conns = append(holdStores.conns, conn)
+}
+
+func eachHoldStore(fn func(name string, db *gorm.DB) error) map[string]string {
+	holdStores.Lock()
+	conns := append([]*dbConn{}, holdStores.conns...)
+	holdStores.Unlock()
+
+	failed := map[string]string{}
+	for _, conn := range conns {
+		db, err := conn.DB()
+		if err == nil {
+			err = fn(conn.name, db)
+		}
+		if err != nil {
+			failed[conn.name] = err.Error()
+		}
+	}
+	return failed
+}
+
+type holdRequest struct {
+	Namespace string     `json:"namespace,omitempty"`
+	UID       string     `json:"uid,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// HoldAPIAuth authenticates the requests of the hold api by the bearer tokens,
+// who places or releases a hold is the user of the token
+type HoldAPIAuth struct {
+	// token to user
+	Tokens map[string]string
+
+	// take the user from the X-Remote-User header, only if the tokens are held by the authenticating proxies setting it
+	TrustRemoteUser bool
+}
+
+// LoadHoldAPITokens reads the token file, one "token,user" per line, the empty lines and the lines starting with # are skipped
+func LoadHoldAPITokens(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read hold api token file failed")
+	}
+	tokens := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		token, user, ok := strings.Cut(line, ",")
+		token, user = strings.TrimSpace(token), strings.TrimSpace(user)
+		if !ok || token == "" || user == "" {
+			return nil, errors.Errorf("invalid hold api token at line %d, token,user expected", i+1)
+		}
+		tokens[token] = user
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no token in the hold api token file")
+	}
+	return tokens, nil
+}
+
+// user returns the user of the request, empty if it's not authenticated
+func (a HoldAPIAuth) user(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	var user string
+	// compare every token in constant time
+	for t, u := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user = u
+		}
+	}
+	if user != "" && a.TrustRemoteUser {
+		return r.Header.Get("X-Remote-User")
+	}
+	return user
+}
+
+type holdUserKey struct{}
+
+// HoldHandler serves the legal holds of the database outputs,
+// GET /holds lists the holds of every output including the released ones,
+// POST /holds places a hold on every output, POST /holds/{id}/release releases it
+func HoldHandler(auth HoldAPIAuth) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /holds", listHolds)
+	mux.HandleFunc("POST /holds", placeHold)
+	mux.HandleFunc("POST /holds/{id}/release", releaseHold)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.user(r)
+		if user == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), holdUserKey{}, user)))
+	})
+}
+
+// decodeHoldRequest decodes the request, and returns the authenticated user as who places or releases the hold
+func decodeHoldRequest(w http.ResponseWriter, r *http.Request) (holdRequest, string, bool) {
+	var req holdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return req, "", false
+	}
+	by, _ := r.Context().Value(holdUserKey{}).(string)
+	return req, by, true
+}
+
+func writeHoldResponse(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func listHolds(w http.ResponseWriter, _ *http.Request) {
+	holds := map[string][]LegalHold{}
+	failed := eachHoldStore(func(name string, db *gorm.DB) error {
+		var list []LegalHold
+		if err := db.Order("placed_at").Find(&list).Error; err != nil {
+			return err
+		}
+		holds[name] = list
+		return nil
+	})
+	writeHoldResponse(w, http.StatusOK, map[string]any{"holds": holds, "failed": failed})
+}
+
+func placeHold(w http.ResponseWriter, r *http.Request) {
+	req, by, ok := decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	hold := LegalHold{
+		ID:        goutils.UUID(),
+		Namespace: req.Namespace,
+		UID:       req.UID,
+		From:      req.From,
+		To:        req.To,
+		Reason:    req.Reason,
+		PlacedBy:  by,
+		PlacedAt:  time.Now(),
+	}
+	if err := hold.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	failed := eachHoldStore(func(name string, db *gorm.DB) error {
+		return db.Create(&hold).Error
+	})
+	log.L.Info("legal hold placed", "id", hold.ID, "by", by, "reason", hold.Reason, "failed", failed)
+	writeHoldResponse(w, cmp.Or(statusOfFailed(failed), http.StatusCreated), map[string]any{"hold": hold, "failed": failed})
+}
+
+func releaseHold(w http.ResponseWriter, r *http.Request) {
+	_, by, ok := decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	now := time.Now()
+
+	var released []string
+	failed := eachHoldStore(func(name string, db *gorm.DB) error {
+		result := db.Model(&LegalHold{}).Where("id = ? AND released_at IS NULL", id).
+			Updates(map[string]any{"released_by": by, "released_at": now})
+		if result.Error == nil && result.RowsAffected > 0 {
+			released = append(released, name)
+		}
+		return result.Error
+	})
+	if len(released) == 0 && len(failed) == 0 {
+		http.Error(w, "active legal hold not found", http.StatusNotFound)
+		return
+	}
+	log.L.Info("legal hold released", "id", id, "by", by, "failed", failed)
+	writeHoldResponse(w, cmp.Or(statusOfFailed(failed), http.StatusOK), map[string]any{"released": released, "failed": failed})
+}
+
+// the hold is placed on or released from the other outputs even if some of them failed
+func statusOfFailed(failed map[string]string) int {
+	if len(failed) > 0 {
+		return http.StatusBadGateway
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var testHoldAPIAuth = HoldAPIAuth{Tokens: map[string]string{"token-alice": "alice", "token-bob": "bob"}}
+
+func serveHold(auth HoldAPIAuth, method, path, body, token string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	HoldHandler(auth).ServeHTTP(w, req)
+	return w
+}
+
+func TestLegalHold(t *testing.T) {
+	ktconfig := &config.KubeTrackConfiguration{
+		Cluster: "test",
+		Holds:   []config.LegalHold{{Namespace: "frozen", Reason: "audit"}},
+	}
+	out := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "kubetrack.db"), TTLDays: 1})
+
+	// only place the holds on this output
+	conns := holdStores.conns
+	holdStores.conns = []*dbConn{out.conn}
+	t.Cleanup(func() { holdStores.conns = conns })
+
+	for _, ref := range []corev1.ObjectReference{
+		{Namespace: "frozen", Name: "a", UID: "uid-a"},
+		{Namespace: "default", Name: "evidence", UID: "uid-evidence"},
+		{Namespace: "default", Name: "b", UID: "uid-b"},
+	} {
+		require.NoError(t, out.Write(OutputStruct{EventTime: time.Now().AddDate(0, 0, -2), ObjectRef: ref}))
+	}
+	names := func() []string {
+		var names []string
+		require.NoError(t, sqliteDB(t, out).Model(&Events{}).Order("id").Pluck("name", &names).Error)
+		return names
+	}
+
+	w := serveHold(testHoldAPIAuth, http.MethodPost, "/holds", `{"uid": "uid-evidence", "reason": "incident 42"}`, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveHold(testHoldAPIAuth, http.MethodGet, "/holds", "", "token-mallory", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveHold(testHoldAPIAuth, http.MethodPost, "/holds", `{"reason": "no condition"}`, "token-alice", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the user comes from the token, the header and the by of the body are ignored
+	w = serveHold(testHoldAPIAuth, http.MethodPost, "/holds", `{"uid": "uid-evidence", "reason": "incident 42", "by": "mallory"}`,
+		"token-alice", http.Header{"X-Remote-User": {"mallory"}})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var placed struct{ Hold LegalHold }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &placed))
+	assert.Equal(t, "alice", placed.Hold.PlacedBy)
+
+	out.doCleanupJob()
+	assert.Equal(t, []string{"a", "evidence"}, names())
+
+	// behind a trusted proxy the user comes from the header
+	proxyAuth := HoldAPIAuth{Tokens: map[string]string{"token-proxy": "proxy"}, TrustRemoteUser: true}
+	w = serveHold(proxyAuth, http.MethodPost, "/holds/"+placed.Hold.ID+"/release", "", "token-proxy", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveHold(proxyAuth, http.MethodPost, "/holds/"+placed.Hold.ID+"/release", "", "token-proxy", http.Header{"X-Remote-User": {"bob"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveHold(testHoldAPIAuth, http.MethodPost, "/holds/"+placed.Hold.ID+"/release", "", "token-bob", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the released hold is kept as the audit record
+	w = serveHold(testHoldAPIAuth, http.MethodGet, "/holds", "", "token-bob", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct{ Holds map[string][]LegalHold }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Holds[out.conn.name], 1)
+	hold := list.Holds[out.conn.name][0]
+	assert.Equal(t, "alice", hold.PlacedBy)
+	assert.Equal(t, "bob", hold.ReleasedBy)
+	assert.NotNil(t, hold.ReleasedAt)
+
+	out.doCleanupJob()
+	assert.Equal(t, []string{"a"}, names())
+}
+
+func TestLegalHold_degraded(t *testing.T) {
+	ktconfig := &config.KubeTrackConfiguration{Cluster: "test"}
+	a := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "a.db"), TTLDays: 1})
+	b := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "b.db"), TTLDays: 1})
+
+	conns := holdStores.conns
+	holdStores.conns = []*dbConn{a.conn, b.conn}
+	t.Cleanup(func() { holdStores.conns = conns })
+
+	for _, out := range []*SqliteOutput{a, b} {
+		for _, ref := range []corev1.ObjectReference{
+			{Namespace: "default", Name: "evidence", UID: "uid-evidence"},
+			{Namespace: "default", Name: "b", UID: "uid-b"},
+		} {
+			require.NoError(t, out.Write(OutputStruct{EventTime: time.Now().AddDate(0, 0, -2), ObjectRef: ref}))
+		}
+	}
+	names := func(out *SqliteOutput) []string {
+		var names []string
+		require.NoError(t, sqliteDB(t, out).Model(&Events{}).Order("id").Pluck("name", &names).Error)
+		return names
+	}
+
+	// b is degraded while the hold is placed
+	bDB := sqliteDB(t, b)
+	b.conn.mu.Lock()
+	b.conn.db, b.conn.err = nil, errors.New("connection refused")
+	b.conn.mu.Unlock()
+
+	w := serveHold(testHoldAPIAuth, http.MethodPost, "/holds", `{"uid": "uid-evidence", "reason": "incident 42"}`, "token-alice", nil)
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	var placed struct {
+		Hold   LegalHold
+		Failed map[string]string
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &placed))
+	assert.Contains(t, placed.Failed, b.conn.name)
+
+	// nothing is deleted while the holds of b are unknown
+	a.doCleanupJob()
+	assert.Equal(t, []string{"evidence", "b"}, names(a))
+
+	// b gets the hold before its retention once it's back
+	b.conn.mu.Lock()
+	b.conn.db, b.conn.err = bDB, nil
+	b.conn.mu.Unlock()
+	b.doCleanupJob()
+	assert.Equal(t, []string{"evidence"}, names(b))
+	var held []string
+	require.NoError(t, bDB.Model(&LegalHold{}).Where("released_at IS NULL").Pluck("id", &held).Error)
+	assert.Equal(t, []string{placed.Hold.ID}, held)
+	a.doCleanupJob()
+	assert.Equal(t, []string{"evidence"}, names(a))
+
+	// the release failed on a is taken from b
+	a.conn.mu.Lock()
+	aDB := a.conn.db
+	a.conn.db, a.conn.err = nil, errors.New("connection refused")
+	a.conn.mu.Unlock()
+	w = serveHold(testHoldAPIAuth, http.MethodPost, "/holds/"+placed.Hold.ID+"/release", "", "token-bob", nil)
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	a.conn.mu.Lock()
+	a.conn.db, a.conn.err = aDB, nil
+	a.conn.mu.Unlock()
+	a.doCleanupJob()
+	assert.Empty(t, names(a))
+	var releasedBy string
+	require.NoError(t, aDB.Model(&LegalHold{}).Where("id = ?", placed.Hold.ID).Pluck("released_by", &releasedBy).Error)
+	assert.Equal(t, "bob", releasedBy)
+}
+
+func TestLoadHoldAPITokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("# token,user\ntoken-alice, alice\n\ntoken-bob,bob\n"), 0o600))
+	tokens, err := LoadHoldAPITokens(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"token-alice": "alice", "token-bob": "bob"}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte("token-alice\n"), 0o600))
+	_, err = LoadHoldAPITokens(path)
+	assert.Error(t, err)
+}
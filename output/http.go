@@ -0,0 +1,80 @@
+package output
+
+import (
+	"cmp"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHTTPTimeout      = 10 * time.Second
+	defaultHTTPMaxRetries   = 3
+	defaultHTTPRetryBackoff = time.Second
+)
+
+func newHTTPClient(tlsConf *config.TLSConfig, timeout time.Duration) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(tlsConf)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cmp.Or(timeout, defaultHTTPTimeout),
+	}, nil
+}
+
+// doHTTPWithRetry sends the request built by newReq and returns the response body, it retries with
+// exponential backoff when the request failed or the server responded with 5xx
+func doHTTPWithRetry(client *http.Client, newReq func() (*http.Request, error), maxRetries int, backoff time.Duration) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			log.L.Info("retrying http request", "attempt", attempt, "backoff", backoff.String(), "reason", lastErr.Error())
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := newReq()
+		if err != nil {
+			return nil, errors.Wrap(err, "build http request failed")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "http %s %s failed", req.Method, req.URL.Redacted())
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			lastErr = errors.Wrapf(err, "read response of http %s %s failed", req.Method, req.URL.Redacted())
+			continue
+		}
+
+		switch {
+		case resp.StatusCode >= 500:
+			lastErr = errors.Errorf("http %s %s failed, status=%d, body=%s", req.Method, req.URL.Redacted(), resp.StatusCode, truncateBody(body))
+			continue
+		case resp.StatusCode >= 300:
+			return body, errors.Errorf("http %s %s failed, status=%d, body=%s", req.Method, req.URL.Redacted(), resp.StatusCode, truncateBody(body))
+		}
+		return body, nil
+	}
+	return nil, lastErr
+}
+
+func truncateBody(body []byte) string {
+	const maxLen = 1024
+	if len(body) > maxLen {
+		return string(body[:maxLen]) + "..."
+	}
+	return string(body)
+}
@@ -1,9 +1,16 @@
 package output
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type Output interface {
@@ -11,6 +18,40 @@ type Output interface {
 	Write(out OutputStruct) error
 }
 
+// Closer is implemented by the outputs holding the records in memory, Close delivers them on shutdown
+type Closer interface {
+	Close() error
+}
+
+// failedHandler is implemented by the outputs writing the records in batches, the records of the failed batches
+// are handed to fn instead of being dropped, and Write returns a handedOverError for them.
+// false if the output doesn't write in batches
+type failedHandler interface {
+	handleFailed(fn func(records []OutputStruct)) bool
+}
+
+// permanentError tells writing the record never succeeds, e.g. the database rejects its data,
+// the record is dropped instead of being retried or spooled
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+func isPermanent(err error) bool {
+	return errors.As(err, new(permanentError))
+}
+
+// CloseOutput closes the output if it's a Closer
+func CloseOutput(out Output) error {
+	if c, ok := out.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 type SourceType string
 
 const (
@@ -39,4 +80,48 @@ type OutputStruct struct {
 	JsonPatch string         `json:"json_patch"`
 	Fields    map[string]any `json:"fields"`
 	Message   string         `json:"message"` // event message
+
+	Labels map[string]string `json:"labels,omitempty"` // labels of the object, used by the output filters
+
+	// identifies the object state of the record, the same record emitted again after a restart or a relist has the same key
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// NewIdempotencyKey hashes the cluster, source, event type, uid and resourceVersion of the object,
+// the resourceVersion must be captured before it's pruned
+func NewIdempotencyKey(cluster string, source SourceType, eventType EventType, uid types.UID, resourceVersion string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{cluster, string(source), string(eventType), string(uid), resourceVersion}, "/")))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsWarning tells if the record comes from a Warning event
+func (out OutputStruct) IsWarning() bool {
+	if out.Source != SourceTypeEvent {
+		return false
+	}
+	evType, _ := out.Object["type"].(string)
+	return evType == corev1.EventTypeWarning || strings.HasPrefix(out.Message, corev1.EventTypeWarning+" ")
+}
+
+// Summary returns the message of the record, or describes the change if the message is empty
+func (out OutputStruct) Summary() string {
+	if out.Message != "" {
+		return strings.TrimSpace(out.Message)
+	}
+	if out.ObjectRef.Namespace == "" {
+		return fmt.Sprintf("%s %s %s", out.EventType, out.ObjectRef.Kind, out.ObjectRef.Name)
+	}
+	return fmt.Sprintf("%s %s %s/%s", out.EventType, out.ObjectRef.Kind, out.ObjectRef.Namespace, out.ObjectRef.Name)
+}
+
+// formatFieldValue formats the field value as text, the maps and the slices are encoded as json
+func formatFieldValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return mustMarshalString(v)
+	}
+	return fmt.Sprintf("%v", v)
 }
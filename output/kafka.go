@@ -0,0 +1,188 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// the writes are synchronous, the writer waits batchTimeout for more messages before sending a batch, 1s by default of kafka-go
+const defaultKafkaBatchTimeout = 10 * time.Millisecond
+
+type KafkaOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputKafka
+	writer   *kafka.Writer
+}
+
+func NewKafkaOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputKafka) *KafkaOutput {
+	if conf == nil {
+		return nil
+	}
+	if err := validateCloudEventsMode(conf.CloudEvents); err != nil {
+		log.L.Error(err, "invalid kafka config")
+		os.Exit(1)
+	}
+	out := &KafkaOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+	}
+	if err := out.initWriter(); err != nil {
+		log.L.Error(err, "init kafka writer failed")
+		os.Exit(1)
+	}
+	log.L.Info("kafka output initialized", "brokers", conf.Brokers, "topic", conf.Topic)
+
+	return out
+}
+
+func (ko *KafkaOutput) Name() string {
+	return "kafka"
+}
+
+func (ko *KafkaOutput) Write(out OutputStruct) error {
+	value, ceHeaders, err := ko.encode(out)
+	if err != nil {
+		return err
+	}
+
+	msg := kafka.Message{
+		Key:   []byte(ko.messageKey(out)),
+		Value: value,
+		Headers: append([]kafka.Header{
+			{Key: "cluster", Value: []byte(ko.ktconfig.Cluster)},
+			{Key: "source", Value: []byte(out.Source)},
+			{Key: "event_type", Value: []byte(out.EventType)},
+			{Key: "idempotency_key", Value: []byte(out.IdempotencyKey)},
+		}, ceHeaders...),
+	}
+	return errors.WithStack(ko.writer.WriteMessages(context.Background(), msg))
+}
+
+// encode returns the message value and the extra headers required by the cloudevents kafka protocol binding
+func (ko *KafkaOutput) encode(out OutputStruct) ([]byte, []kafka.Header, error) {
+	switch ko.conf.CloudEvents {
+	case config.CloudEventsModeStructured:
+		value, err := json.Marshal(NewCloudEvent(ko.ktconfig.Cluster, out))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "marshal cloudevent failed")
+		}
+		return value, []kafka.Header{{Key: "content-type", Value: []byte(CloudEventsContentType)}}, nil
+	case config.CloudEventsModeBinary:
+		ce := NewCloudEvent(ko.ktconfig.Cluster, out)
+		value, err := json.Marshal(ce.Data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "marshal cloudevent data failed")
+		}
+		attrs := ce.Attributes()
+		headers := []kafka.Header{{Key: "content-type", Value: []byte(ce.DataContentType)}}
+		for _, k := range slices.Sorted(maps.Keys(attrs)) {
+			headers = append(headers, kafka.Header{Key: "ce_" + k, Value: []byte(attrs[k])})
+		}
+		return value, headers, nil
+	default:
+		value, err := json.Marshal(out)
+		return value, nil, errors.Wrap(err, "marshal output struct failed")
+	}
+}
+
+func (ko *KafkaOutput) messageKey(out OutputStruct) string {
+	switch ko.conf.KeyStrategy {
+	case config.KafkaKeyStrategyNamespacedName:
+		if out.ObjectRef.Namespace == "" {
+			return out.ObjectRef.Name
+		}
+		return fmt.Sprintf("%s/%s", out.ObjectRef.Namespace, out.ObjectRef.Name)
+	case config.KafkaKeyStrategyKind:
+		return fmt.Sprintf("%s/%s", out.ObjectRef.APIVersion, out.ObjectRef.Kind)
+	default:
+		return string(out.ObjectRef.UID)
+	}
+}
+
+func (ko *KafkaOutput) initWriter() error {
+	if len(ko.conf.Brokers) == 0 {
+		return errors.New("kafka brokers not set")
+	}
+	if ko.conf.Topic == "" {
+		return errors.New("kafka topic not set")
+	}
+
+	acks := kafka.RequireAll
+	if ko.conf.Acks != "" {
+		if err := acks.UnmarshalText([]byte(ko.conf.Acks)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	var compression kafka.Compression
+	if ko.conf.Compression != "" {
+		if err := compression.UnmarshalText([]byte(ko.conf.Compression)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(ko.conf.TLS)
+	if err != nil {
+		return err
+	}
+
+	mechanism, err := ko.saslMechanism()
+	if err != nil {
+		return err
+	}
+
+	batchTimeout := ko.conf.BatchTimeout.Duration
+	if batchTimeout <= 0 {
+		batchTimeout = defaultKafkaBatchTimeout
+	}
+
+	ko.writer = &kafka.Writer{
+		Addr:         kafka.TCP(ko.conf.Brokers...),
+		Topic:        ko.conf.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		Compression:  compression,
+		BatchSize:    ko.conf.BatchSize,
+		BatchTimeout: batchTimeout,
+		Transport: &kafka.Transport{
+			TLS:  tlsConfig,
+			SASL: mechanism,
+		},
+	}
+	return nil
+}
+
+func (ko *KafkaOutput) saslMechanism() (sasl.Mechanism, error) {
+	if ko.conf.SASL == nil {
+		return nil, nil
+	}
+
+	switch ko.conf.SASL.Mechanism {
+	case "plain", "":
+		return plain.Mechanism{
+			Username: ko.conf.SASL.Username,
+			Password: ko.conf.SASL.Password,
+		}, nil
+	case "scram-sha-256":
+		mechanism, err := scram.Mechanism(scram.SHA256, ko.conf.SASL.Username, ko.conf.SASL.Password)
+		return mechanism, errors.WithStack(err)
+	case "scram-sha-512":
+		mechanism, err := scram.Mechanism(scram.SHA512, ko.conf.SASL.Username, ko.conf.SASL.Password)
+		return mechanism, errors.WithStack(err)
+	default:
+		return nil, errors.Errorf("unknown sasl mechanism: %s", ko.conf.SASL.Mechanism)
+	}
+}
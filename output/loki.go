@@ -0,0 +1,193 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultLokiBatchSize     = 500
+	defaultLokiBatchInterval = time.Second
+)
+
+type LokiOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputLoki
+	client   *http.Client
+	batcher  *batcher[OutputStruct]
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+// lokiLine is the log line of a record, the labels are not repeated here
+type lokiLine struct {
+	APIVersion string         `json:"api_version"`
+	Name       string         `json:"name"`
+	UID        string         `json:"uid"`
+	Message    string         `json:"message,omitempty"`
+	Fields     map[string]any `json:"fields,omitempty"`
+}
+
+func NewLokiOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputLoki) *LokiOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.URL == "" {
+		log.L.Error(nil, "loki url not set")
+		os.Exit(1)
+	}
+
+	client, err := newHTTPClient(conf.TLS, conf.Timeout.Duration)
+	if err != nil {
+		log.L.Error(err, "init loki http client failed")
+		os.Exit(1)
+	}
+
+	out := &LokiOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+	out.batcher = newBatcher(out.Name(), cmp.Or(conf.BatchSize, defaultLokiBatchSize), cmp.Or(conf.BatchInterval.Duration, defaultLokiBatchInterval), out.push)
+
+	return out
+}
+
+func (lo *LokiOutput) Name() string {
+	return "loki"
+}
+
+func (lo *LokiOutput) Write(out OutputStruct) error {
+	return lo.batcher.Add(out)
+}
+
+// Close pushes the pending records
+func (lo *LokiOutput) Close() error {
+	return lo.batcher.Close()
+}
+
+func (lo *LokiOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	lo.batcher.handleFailed(fn)
+	return true
+}
+
+func (lo *LokiOutput) labels(out OutputStruct) map[string]string {
+	labels := make(map[string]string, len(lo.conf.ExtraLabels)+5)
+	for k, v := range lo.conf.ExtraLabels {
+		labels[k] = v
+	}
+	labels["cluster"] = lo.ktconfig.Cluster
+	labels["namespace"] = out.ObjectRef.Namespace
+	labels["kind"] = out.ObjectRef.Kind
+	labels["source"] = string(out.Source)
+	labels["event_type"] = string(out.EventType)
+	return labels
+}
+
+func (lo *LokiOutput) push(outs []OutputStruct) error {
+	// group the records by stream labels
+	streams := make(map[string]*lokiStream)
+	for _, out := range outs {
+		labels := lo.labels(out)
+		key := labelsKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+		}
+
+		line, err := json.Marshal(lokiLine{
+			APIVersion: out.ObjectRef.APIVersion,
+			Name:       out.ObjectRef.Name,
+			UID:        string(out.ObjectRef.UID),
+			Message:    out.Message,
+			Fields:     out.Fields,
+		})
+		if err != nil {
+			return errors.Wrap(err, "marshal loki line failed")
+		}
+		stream.entries = append(stream.entries, lokiEntry{ts: out.EventTime, line: string(line)})
+	}
+
+	req := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, stream := range streams {
+		// entries must be in order within a stream
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].ts.Before(stream.entries[j].ts)
+		})
+		for _, entry := range stream.entries {
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), entry.line})
+		}
+		req.Streams = append(req.Streams, stream)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "marshal loki push request failed")
+	}
+
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, lo.conf.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if lo.conf.TenantID != "" {
+			req.Header.Set("X-Scope-OrgID", lo.conf.TenantID)
+		}
+		switch {
+		case lo.conf.BearerToken != "":
+			req.Header.Set("Authorization", "Bearer "+lo.conf.BearerToken)
+		case lo.conf.Username != "":
+			req.SetBasicAuth(lo.conf.Username, lo.conf.Password)
+		}
+		return req, nil
+	}
+
+	maxRetries := defaultHTTPMaxRetries
+	if lo.conf.MaxRetries != nil {
+		maxRetries = *lo.conf.MaxRetries
+	}
+	_, err = doHTTPWithRetry(lo.client, newReq, maxRetries, cmp.Or(lo.conf.RetryBackoff.Duration, defaultHTTPRetryBackoff))
+	return err
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
@@ -2,29 +2,38 @@ package output
 
 import (
 	"os"
+	"time"
 
 	"github.com/major1201/kubetrack/config"
 	"github.com/major1201/kubetrack/gormutils"
 	"github.com/major1201/kubetrack/log"
 	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
 )
 
 type MysqlOutput struct {
-	ktconfig *config.KubeTrackConfiguration
-	conf     *config.OutputMysql
+	ktconfig  *config.KubeTrackConfiguration
+	conn      *dbConn
+	batcher   *batcher[OutputStruct]
+	conf      *config.OutputMysql
+	retention []retentionPolicy
 }
 
 func NewMysqlOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputMysql) *MysqlOutput {
 	if conf == nil {
 		return nil
 	}
+	if conf.DSN == "" {
+		log.L.Error(nil, "mysql dsn not set")
+		os.Exit(1)
+	}
 	res := &MysqlOutput{
 		ktconfig: ktconfig,
 		conf:     conf,
 	}
-	res.initDB()
-	res.migrate()
+	res.conn = newDBConn(res.Name(), res.connect)
 	res.initCleanupJob()
+	res.batcher = newDBBatcher(res.Name(), res.conn, ktconfig.Cluster, conf.FlushSize, conf.FlushInterval.Duration)
 
 	return res
 }
@@ -34,54 +43,45 @@ func (lo *MysqlOutput) Name() string {
 }
 
 func (lo *MysqlOutput) Write(out OutputStruct) error {
-	ev := &Events{
-		Cluster:   lo.ktconfig.Cluster,
-		EventTime: out.EventTime,
-		Source:    string(out.Source),
-		EventType: string(out.EventType),
-
-		APIVersion: out.ObjectRef.APIVersion,
-		Kind:       out.ObjectRef.Kind,
-		Namespace:  out.ObjectRef.Namespace,
-		Name:       out.ObjectRef.Name,
-		UID:        string(out.ObjectRef.UID),
-
-		Fields:  gormutils.MustToJsonb(out.Fields),
-		Message: out.Message,
-
-		Object:    gormutils.MustToJsonb(out.Object),
-		Diff:      out.Diff,
-		JsonPatch: gormutils.MustToJsonb(out.JsonPatch),
+	if lo.batcher != nil {
+		return lo.batcher.Add(out)
 	}
-	return gormutils.Save(ev)
-}
-
-func (lo *MysqlOutput) initDB() {
-	os.Setenv(gormutils.EnvDBDriver, "mysql")
-	os.Setenv(gormutils.EnvDBConnection, lo.conf.DSN)
-	// try db connection
-	sqlDB, err := gormutils.GetDB().DB()
+	db, err := lo.conn.DB()
 	if err != nil {
-		log.L.Error(err, "get sql db failed")
-		os.Exit(1)
+		return err
 	}
-	if err = sqlDB.Ping(); err != nil {
-		log.L.Error(err, "db connect failed")
-		os.Exit(1)
+	return insertEvents(db, lo.ktconfig.Cluster, out)
+}
+
+// Close inserts the pending records
+func (lo *MysqlOutput) Close() error {
+	if lo.batcher != nil {
+		return lo.batcher.Close()
 	}
+	return nil
 }
 
-func (lo *MysqlOutput) migrate() {
-	log.L.Info("migrating mysql")
+// open opens the database without migrating it
+func (lo *MysqlOutput) open() (*gorm.DB, error) {
+	return openDB("mysql", lo.conf.DSN, lo.conf.DBPool)
+}
 
-	if err := gormutils.GetDB().AutoMigrate(&Events{}); err != nil {
-		log.L.Error(err, "migrate error")
-		os.Exit(1)
+// connect opens and migrates the database
+func (lo *MysqlOutput) connect() (*gorm.DB, error) {
+	db, err := lo.open()
+	if err != nil {
+		return nil, err
 	}
+	if err := migrateEvents(db, false, nil); err != nil {
+		closeDB(db)
+		return nil, err
+	}
+	return db, nil
 }
 
 func (lo *MysqlOutput) initCleanupJob() {
-	if lo.conf.TTLDays <= 0 {
+	lo.retention = retentionPolicies(lo.ktconfig, lo.conf.TTLDays)
+	if !hasRetention(lo.retention) {
 		return
 	}
 
@@ -95,7 +95,26 @@ func (lo *MysqlOutput) initCleanupJob() {
 
 func (lo *MysqlOutput) doCleanupJob() {
 	log.L.Info("running cleanup job", "ttlDays", lo.conf.TTLDays)
-	if err := gormutils.Delete(&Events{}, "created_at < now() - interval ? day", lo.conf.TTLDays); err != nil {
+	db, err := lo.conn.DB()
+	if err != nil {
+		log.L.Error(err, "cron: skip cleanup job")
+		return
+	}
+	// nothing is deleted if the holds are unknown
+	holds, err := syncHolds(db, lo.ktconfig)
+	if err != nil {
+		log.L.Error(err, "cron: skip cleanup job")
+		return
+	}
+	exempt := newRetentionExemption(db.Dialector.Name(), holds, lo.ktconfig)
+	// the kept records are looked up once in a temporary table of the connection
+	if err := gormutils.WithConn(db, func(tx *gorm.DB) error {
+		if err := exempt.saveKept(tx, "events"); err != nil {
+			return err
+		}
+		defer exempt.dropKept(tx)
+		return deleteExpiredEvents(tx, lo.retention, exempt, time.Now())
+	}); err != nil {
 		log.L.Error(err, "cron: delete data failed")
 	}
 }
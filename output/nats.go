@@ -0,0 +1,205 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/tmpl"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultNATSSubject       = "kubetrack.{{ .ObjectRef.Kind | lower }}.{{ .ObjectRef.Namespace }}"
+	defaultNATSStreamSubject = "kubetrack.>"
+	defaultNATSTimeout       = 10 * time.Second
+)
+
+type NATSOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputNATS
+	subject  *template.Template
+	conn     *nats.Conn
+	js       jetstream.JetStream
+}
+
+func NewNATSOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputNATS) *NATSOutput {
+	if conf == nil {
+		return nil
+	}
+	if len(conf.Servers) == 0 {
+		log.L.Error(nil, "nats servers not set")
+		os.Exit(1)
+	}
+	if err := validateCloudEventsMode(conf.CloudEvents); err != nil {
+		log.L.Error(err, "invalid nats config")
+		os.Exit(1)
+	}
+
+	subject, err := template.New("subject").Funcs(tmpl.GetFuncMap().TextFuncMap()).Parse(cmp.Or(conf.Subject, defaultNATSSubject))
+	if err != nil {
+		log.L.Error(err, "parse nats subject template failed")
+		os.Exit(1)
+	}
+
+	out := &NATSOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		subject:  subject,
+	}
+	if err := out.connect(); err != nil {
+		log.L.Error(err, "connect to nats failed")
+		os.Exit(1)
+	}
+	log.L.Info("nats output initialized", "servers", conf.Servers, "stream", conf.Stream)
+
+	return out
+}
+
+func (no *NATSOutput) Name() string {
+	return "nats"
+}
+
+func (no *NATSOutput) Write(out OutputStruct) error {
+	subject, err := no.renderSubject(out)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Header.Set("cluster", no.ktconfig.Cluster)
+	msg.Header.Set("source", string(out.Source))
+	msg.Header.Set("event_type", string(out.EventType))
+	if out.IdempotencyKey != "" {
+		// deduplicated by jetstream within the duplicate window of the stream
+		msg.Header.Set(nats.MsgIdHdr, out.IdempotencyKey)
+	}
+
+	switch no.conf.CloudEvents {
+	case config.CloudEventsModeStructured:
+		msg.Data, err = json.Marshal(NewCloudEvent(no.ktconfig.Cluster, out))
+		msg.Header.Set("content-type", CloudEventsContentType)
+	case config.CloudEventsModeBinary:
+		ce := NewCloudEvent(no.ktconfig.Cluster, out)
+		msg.Data, err = json.Marshal(ce.Data)
+		msg.Header.Set("content-type", ce.DataContentType)
+		for k, v := range ce.Attributes() {
+			msg.Header.Set("ce-"+k, v)
+		}
+	default:
+		msg.Data, err = json.Marshal(out)
+	}
+	if err != nil {
+		return errors.Wrap(err, "marshal nats message failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(no.conf.Timeout.Duration, defaultNATSTimeout))
+	defer cancel()
+	_, err = no.js.PublishMsg(ctx, msg)
+	return errors.Wrapf(err, "publish to nats subject %s failed", subject)
+}
+
+// renderSubject renders the subject template, tokens which are empty or contain wildcards are replaced with _
+func (no *NATSOutput) renderSubject(out OutputStruct) (string, error) {
+	var buf bytes.Buffer
+	if err := no.subject.Execute(&buf, out); err != nil {
+		return "", errors.Wrap(err, "render nats subject failed")
+	}
+
+	tokens := strings.Split(buf.String(), ".")
+	for i, token := range tokens {
+		token = strings.Join(strings.Fields(token), "_")
+		if token == "" || token == "*" || token == ">" {
+			token = "_"
+		}
+		tokens[i] = token
+	}
+	return strings.Join(tokens, "."), nil
+}
+
+func (no *NATSOutput) connect() error {
+	opts := []nats.Option{
+		nats.Name("kubetrack"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.L.Error(err, "nats disconnected")
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.L.Info("nats reconnected", "url", nc.ConnectedUrl())
+		}),
+	}
+	if no.conf.Username != "" {
+		opts = append(opts, nats.UserInfo(no.conf.Username, no.conf.Password))
+	}
+	if no.conf.Token != "" {
+		opts = append(opts, nats.Token(no.conf.Token))
+	}
+	if no.conf.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(no.conf.CredsFile))
+	}
+	tlsConf, err := newTLSConfig(no.conf.TLS)
+	if err != nil {
+		return err
+	}
+	if tlsConf != nil {
+		opts = append(opts, nats.Secure(tlsConf))
+	}
+
+	no.conn, err = nats.Connect(strings.Join(no.conf.Servers, ","), opts...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	no.js, err = jetstream.New(no.conn)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if no.conf.Stream != "" {
+		subjects := no.conf.StreamSubjects
+		if len(subjects) == 0 {
+			subjects = []string{defaultNATSStreamSubject}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(no.conf.Timeout.Duration, defaultNATSTimeout))
+		defer cancel()
+		if err := no.ensureStream(ctx, subjects); err != nil {
+			return errors.Wrapf(err, "ensure nats stream %s failed", no.conf.Stream)
+		}
+	}
+	return nil
+}
+
+// ensureStream creates the stream if it doesn't exist, otherwise only adds the missing subjects to it,
+// the other settings of the existing stream are left to the operator
+func (no *NATSOutput) ensureStream(ctx context.Context, subjects []string) error {
+	stream, err := no.js.Stream(ctx, no.conf.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		log.L.Info("creating nats stream", "stream", no.conf.Stream, "subjects", subjects)
+		_, err = no.js.CreateStream(ctx, jetstream.StreamConfig{Name: no.conf.Stream, Subjects: subjects})
+		return errors.WithStack(err)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	cfg := stream.CachedInfo().Config
+	missing := slices.DeleteFunc(slices.Clone(subjects), func(s string) bool { return slices.Contains(cfg.Subjects, s) })
+	if len(missing) == 0 {
+		return nil
+	}
+	log.L.Info("adding subjects to nats stream", "stream", no.conf.Stream, "subjects", missing)
+	cfg.Subjects = append(cfg.Subjects, missing...)
+	_, err = no.js.UpdateStream(ctx, cfg)
+	return errors.WithStack(err)
+}
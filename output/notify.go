@@ -0,0 +1,211 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/tmpl"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultNotifyGroupWindow          = 10 * time.Second
+	defaultNotifyMaxRecordsPerMessage = 20
+	notifyMaxPending                  = 10000
+	discordMaxContentLength           = 2000
+
+	defaultNotifyTemplate = `{{ .Total }} change(s) in cluster {{ .Cluster }}
+{{ range .Records }}- {{ .EventType }} {{ .ObjectRef.Kind }} {{ with .ObjectRef.Namespace }}{{ . }}/{{ end }}{{ .ObjectRef.Name }}{{ with .Message }}: {{ . }}{{ end }}
+{{ end }}{{ if .Omitted }}... and {{ .Omitted }} more
+{{ end }}`
+)
+
+type NotifyOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputNotify
+	client   *http.Client
+	template *template.Template
+
+	mu      sync.Mutex
+	pending []OutputStruct
+	timer   *time.Timer // sends the pending records at the end of the group window
+	err     error       // of the last send, the failed records are sent again with the next group
+	closed  bool
+}
+
+// notifyData is the data of the message template
+type notifyData struct {
+	Cluster string
+	Records []OutputStruct
+	Omitted int
+	Total   int
+}
+
+func NewNotifyOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputNotify) *NotifyOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.WebhookURL == "" {
+		log.L.Error(nil, "notify webhook url not set")
+		os.Exit(1)
+	}
+	switch conf.Platform {
+	case config.NotifyPlatformSlack, config.NotifyPlatformMattermost, config.NotifyPlatformTeams, config.NotifyPlatformDiscord:
+	default:
+		log.L.Error(nil, "unknown notify platform", "platform", conf.Platform)
+		os.Exit(1)
+	}
+
+	t, err := template.New("notify").Funcs(tmpl.GetFuncMap().TextFuncMap()).Parse(cmp.Or(conf.Template, defaultNotifyTemplate))
+	if err != nil {
+		log.L.Error(err, "parse notify template failed")
+		os.Exit(1)
+	}
+
+	client, err := newHTTPClient(conf.TLS, conf.Timeout.Duration)
+	if err != nil {
+		log.L.Error(err, "init notify http client failed")
+		os.Exit(1)
+	}
+
+	return &NotifyOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+		template: t,
+	}
+}
+
+func (no *NotifyOutput) Name() string {
+	return "notify"
+}
+
+// Write holds the record and sends all the records held in the group window in one message,
+// the error of the last send is returned until a send succeeds
+func (no *NotifyOutput) Write(out OutputStruct) error {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+
+	no.schedule()
+	no.pending = append(no.pending, out)
+	return no.err
+}
+
+// schedule starts the group window if it's not started, the caller must hold the lock
+func (no *NotifyOutput) schedule() {
+	if no.timer != nil || no.closed {
+		return
+	}
+	no.timer = time.AfterFunc(cmp.Or(no.conf.GroupWindow.Duration, defaultNotifyGroupWindow), func() {
+		if err := no.flush(); err != nil {
+			log.L.Error(err, "send notification failed", "platform", no.conf.Platform)
+		}
+	})
+}
+
+// Close sends the pending records
+func (no *NotifyOutput) Close() error {
+	no.mu.Lock()
+	no.closed = true
+	if no.timer != nil {
+		no.timer.Stop()
+	}
+	no.mu.Unlock()
+	return no.flush()
+}
+
+// flush sends the pending records, they're kept for the next group if the send failed
+func (no *NotifyOutput) flush() error {
+	no.mu.Lock()
+	records := no.pending
+	no.pending, no.timer = nil, nil
+	no.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	err := no.send(records)
+
+	no.mu.Lock()
+	defer no.mu.Unlock()
+	no.err = err
+	if err == nil {
+		return nil
+	}
+	no.pending = append(records[:len(records):len(records)], no.pending...)
+	if dropped := len(no.pending) - notifyMaxPending; dropped > 0 {
+		log.L.Error(nil, "too many notifications pending, dropping the oldest records", "platform", no.conf.Platform, "dropped", dropped)
+		no.pending = no.pending[dropped:]
+	}
+	no.schedule()
+	return errors.Wrapf(err, "send %d records failed", len(records))
+}
+
+func (no *NotifyOutput) send(records []OutputStruct) error {
+	data := notifyData{
+		Cluster: no.ktconfig.Cluster,
+		Records: records,
+		Total:   len(records),
+	}
+	if maxRecords := cmp.Or(no.conf.MaxRecordsPerMessage, defaultNotifyMaxRecordsPerMessage); len(records) > maxRecords {
+		data.Records = records[:maxRecords]
+		data.Omitted = len(records) - maxRecords
+	}
+
+	buf := new(bytes.Buffer)
+	if err := no.template.Execute(buf, data); err != nil {
+		return errors.Wrap(err, "render notify template failed")
+	}
+
+	body, err := json.Marshal(no.payload(buf.String()))
+	if err != nil {
+		return errors.Wrap(err, "marshal notify payload failed")
+	}
+
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, no.conf.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	maxRetries := defaultHTTPMaxRetries
+	if no.conf.MaxRetries != nil {
+		maxRetries = *no.conf.MaxRetries
+	}
+	_, err = doHTTPWithRetry(no.client, newReq, maxRetries, cmp.Or(no.conf.RetryBackoff.Duration, defaultHTTPRetryBackoff))
+	return err
+}
+
+// payload builds the webhook payload of the platform
+func (no *NotifyOutput) payload(message string) any {
+	switch no.conf.Platform {
+	case config.NotifyPlatformDiscord:
+		if runes := []rune(message); len(runes) > discordMaxContentLength {
+			message = string(runes[:discordMaxContentLength-3]) + "..."
+		}
+		return map[string]any{"content": message}
+	case config.NotifyPlatformTeams:
+		return map[string]any{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "kubetrack changes",
+			// teams renders markdown, which needs two line breaks to start a new line
+			"text": strings.ReplaceAll(message, "\n", "\n\n"),
+		}
+	default:
+		// slack and mattermost
+		return map[string]any{"text": message}
+	}
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNotifyOutput_Close(t *testing.T) {
+	var mu sync.Mutex
+	var messages []string
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		messages = append(messages, payload["text"])
+	}))
+	defer srv.Close()
+
+	maxRetries := 0
+	out := NewNotifyOutput(&config.KubeTrackConfiguration{Cluster: "test"}, &config.OutputNotify{
+		Platform:    config.NotifyPlatformSlack,
+		WebhookURL:  srv.URL,
+		GroupWindow: metav1.Duration{Duration: time.Hour},
+		MaxRetries:  &maxRetries,
+	})
+	record := func(name string) OutputStruct {
+		return OutputStruct{EventType: EventTypeAdd, ObjectRef: corev1.ObjectReference{Kind: "Pod", Name: name}}
+	}
+
+	// the failed records are kept, and the error is returned until a send succeeds
+	require.NoError(t, out.Write(record("a")))
+	assert.Error(t, out.flush())
+	assert.Error(t, out.Write(record("b")))
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	require.NoError(t, out.Close())
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "2 change(s) in cluster test")
+	assert.Contains(t, messages[0], "Pod a")
+	assert.Contains(t, messages[0], "Pod b")
+}
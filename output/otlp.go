@@ -0,0 +1,311 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultOTLPBatchSize     = 512
+	defaultOTLPBatchInterval = time.Second
+	defaultOTLPTimeout       = 10 * time.Second
+	otlpHTTPLogsPath         = "/v1/logs"
+)
+
+type OTLPOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputOTLP
+	batcher  *batcher[OutputStruct]
+
+	// grpc
+	grpcClient collogspb.LogsServiceClient
+
+	// http
+	httpClient *http.Client
+	httpURL    string
+}
+
+func NewOTLPOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputOTLP) *OTLPOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.Endpoint == "" {
+		log.L.Error(nil, "otlp endpoint not set")
+		os.Exit(1)
+	}
+
+	out := &OTLPOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+	}
+
+	var err error
+	switch conf.Protocol {
+	case "", "grpc":
+		err = out.initGRPC()
+	case "http":
+		err = out.initHTTP()
+	default:
+		err = errors.Errorf("unknown otlp protocol: %s", conf.Protocol)
+	}
+	if err != nil {
+		log.L.Error(err, "init otlp exporter failed")
+		os.Exit(1)
+	}
+	out.batcher = newBatcher(out.Name(), cmp.Or(conf.BatchSize, defaultOTLPBatchSize), cmp.Or(conf.BatchInterval.Duration, defaultOTLPBatchInterval), out.export)
+
+	return out
+}
+
+func (oo *OTLPOutput) Name() string {
+	return "otlp"
+}
+
+func (oo *OTLPOutput) Write(out OutputStruct) error {
+	return oo.batcher.Add(out)
+}
+
+// Close exports the pending records
+func (oo *OTLPOutput) Close() error {
+	return oo.batcher.Close()
+}
+
+func (oo *OTLPOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	oo.batcher.handleFailed(fn)
+	return true
+}
+
+func (oo *OTLPOutput) initGRPC() error {
+	creds := insecure.NewCredentials()
+	if !oo.conf.Insecure {
+		tlsConfig, err := newTLSConfig(oo.conf.TLS)
+		if err != nil {
+			return err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(oo.conf.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return errors.Wrap(err, "create grpc client failed")
+	}
+	oo.grpcClient = collogspb.NewLogsServiceClient(conn)
+	return nil
+}
+
+func (oo *OTLPOutput) initHTTP() error {
+	u, err := url.Parse(oo.conf.Endpoint)
+	if err != nil {
+		return errors.Wrap(err, "parse otlp endpoint failed")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpHTTPLogsPath
+	}
+	oo.httpURL = u.String()
+
+	oo.httpClient, err = newHTTPClient(oo.conf.TLS, cmp.Or(oo.conf.Timeout.Duration, defaultOTLPTimeout))
+	return err
+}
+
+func (oo *OTLPOutput) export(outs []OutputStruct) error {
+	req := oo.buildRequest(outs, time.Now())
+
+	var (
+		resp *collogspb.ExportLogsServiceResponse
+		err  error
+	)
+	if oo.grpcClient != nil {
+		resp, err = oo.exportGRPC(req)
+	} else {
+		resp, err = oo.exportHTTP(req)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ps := resp.GetPartialSuccess(); ps != nil && ps.GetRejectedLogRecords() > 0 {
+		return errors.Errorf("otlp export partially failed, rejected=%d, total=%d, message=%s", ps.GetRejectedLogRecords(), len(outs), ps.GetErrorMessage())
+	}
+	return nil
+}
+
+func (oo *OTLPOutput) exportGRPC(req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(oo.conf.Timeout.Duration, defaultOTLPTimeout))
+	defer cancel()
+	if len(oo.conf.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(oo.conf.Headers))
+	}
+
+	resp, err := oo.grpcClient.Export(ctx, req)
+	return resp, errors.Wrap(err, "otlp grpc export failed")
+}
+
+func (oo *OTLPOutput) exportHTTP(req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal otlp request failed")
+	}
+
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, oo.httpURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		for k, v := range oo.conf.Headers {
+			req.Header.Set(k, v)
+		}
+		return req, nil
+	}
+
+	respBody, err := doHTTPWithRetry(oo.httpClient, newReq, defaultHTTPMaxRetries, defaultHTTPRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if err := proto.Unmarshal(respBody, resp); err != nil {
+		return nil, errors.Wrap(err, "unmarshal otlp response failed")
+	}
+	return resp, nil
+}
+
+// buildRequest groups the records by the object they belong to, every object is a resource
+func (oo *OTLPOutput) buildRequest(outs []OutputStruct, now time.Time) *collogspb.ExportLogsServiceRequest {
+	resourceLogs := make(map[string]*logspb.ResourceLogs)
+	var keys []string
+	for _, out := range outs {
+		key := fmt.Sprintf("%s/%s/%s/%s/%s", out.ObjectRef.APIVersion, out.ObjectRef.Kind, out.ObjectRef.Namespace, out.ObjectRef.Name, out.ObjectRef.UID)
+		rl, ok := resourceLogs[key]
+		if !ok {
+			rl = &logspb.ResourceLogs{
+				Resource: &resourcepb.Resource{Attributes: oo.resourceAttributes(out)},
+				ScopeLogs: []*logspb.ScopeLogs{{
+					Scope: &commonpb.InstrumentationScope{Name: "kubetrack"},
+				}},
+			}
+			resourceLogs[key] = rl
+			keys = append(keys, key)
+		}
+		rl.ScopeLogs[0].LogRecords = append(rl.ScopeLogs[0].LogRecords, oo.logRecord(out, now))
+	}
+
+	sort.Strings(keys)
+	req := &collogspb.ExportLogsServiceRequest{}
+	for _, key := range keys {
+		req.ResourceLogs = append(req.ResourceLogs, resourceLogs[key])
+	}
+	return req
+}
+
+func (oo *OTLPOutput) resourceAttributes(out OutputStruct) []*commonpb.KeyValue {
+	kind := strings.ToLower(out.ObjectRef.Kind)
+	attrs := []*commonpb.KeyValue{
+		otlpStringAttr("service.name", "kubetrack"),
+		otlpStringAttr("k8s.cluster.name", oo.ktconfig.Cluster),
+	}
+	if out.ObjectRef.Namespace != "" {
+		attrs = append(attrs, otlpStringAttr("k8s.namespace.name", out.ObjectRef.Namespace))
+	}
+	if kind != "" {
+		attrs = append(attrs,
+			otlpStringAttr(fmt.Sprintf("k8s.%s.name", kind), out.ObjectRef.Name),
+			otlpStringAttr(fmt.Sprintf("k8s.%s.uid", kind), string(out.ObjectRef.UID)),
+		)
+	}
+	return attrs
+}
+
+func (oo *OTLPOutput) logRecord(out OutputStruct, now time.Time) *logspb.LogRecord {
+	severity, severityText := logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+	if out.IsWarning() {
+		severity, severityText = logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+	}
+
+	attrs := []*commonpb.KeyValue{
+		otlpStringAttr("kubetrack.source", string(out.Source)),
+		otlpStringAttr("kubetrack.event_type", string(out.EventType)),
+		otlpStringAttr("k8s.object.api_version", out.ObjectRef.APIVersion),
+		otlpStringAttr("k8s.object.kind", out.ObjectRef.Kind),
+	}
+	names := make([]string, 0, len(out.Fields))
+	for name := range out.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrs = append(attrs, &commonpb.KeyValue{Key: name, Value: otlpValue(out.Fields[name])})
+	}
+
+	return &logspb.LogRecord{
+		TimeUnixNano:         uint64(out.EventTime.UnixNano()),
+		ObservedTimeUnixNano: uint64(now.UnixNano()),
+		SeverityNumber:       severity,
+		SeverityText:         severityText,
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: out.Summary()}},
+		Attributes:           attrs,
+	}
+}
+
+func otlpStringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// otlpValue converts the field value, the maps and the slices decoded from json become the kvlist and the array values
+func otlpValue(v any) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case nil:
+		return &commonpb.AnyValue{}
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		kvlist := &commonpb.KeyValueList{}
+		for _, key := range keys {
+			kvlist.Values = append(kvlist.Values, &commonpb.KeyValue{Key: key, Value: otlpValue(v[key])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: kvlist}}
+	case []any:
+		array := &commonpb.ArrayValue{}
+		for _, item := range v {
+			array.Values = append(array.Values, otlpValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: array}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: formatFieldValue(v)}}
+}
@@ -0,0 +1,34 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPOutput_logRecord(t *testing.T) {
+	oo := &OTLPOutput{ktconfig: &config.KubeTrackConfiguration{Cluster: "prod"}, conf: &config.OutputOTLP{}}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	record := oo.logRecord(OutputStruct{
+		EventTime: now,
+		EventType: EventTypeUpdate,
+		Source:    SourceTypeGeneral,
+		Fields:    map[string]any{"labels": map[string]any{"app": "nginx"}, "ports": []any{float64(80)}, "ready": true},
+	}, now)
+
+	attrs := map[string]*commonpb.AnyValue{}
+	for _, attr := range record.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.True(t, proto.Equal(&commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+		Values: []*commonpb.KeyValue{otlpStringAttr("app", "nginx")},
+	}}}, attrs["labels"]))
+	assert.True(t, proto.Equal(&commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{
+		Values: []*commonpb.AnyValue{{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 80}}},
+	}}}, attrs["ports"]))
+	assert.True(t, proto.Equal(&commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}, attrs["ready"]))
+}
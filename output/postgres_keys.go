@@ -0,0 +1,71 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// the ids of a table backfilled in a transaction
+const eventsKeysBackfillBatch = 10000
+
+// the tables listed in events_keys_backfill by migration 8
+var eventsKeysBackfillTables = map[string]bool{"events": true, "events_legacy": true}
+
+// backfillEventsKeys claims the idempotency keys of the records written before the events_keys table in batches,
+// instead of in the startup migration, the records emitted again before their keys are backfilled may be duplicated
+func backfillEventsKeys(ctx context.Context, db *gorm.DB, batch int64) error {
+	// the databases migrated by the older versions were backfilled in the migration
+	if !db.Migrator().HasTable("events_keys_backfill") {
+		return nil
+	}
+	for ctx.Err() == nil {
+		more, err := backfillEventsKeysBatch(db.WithContext(ctx), batch)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillEventsKeysBatch claims the keys of the next batch of ids of a table, false if there are none left
+func backfillEventsKeysBatch(db *gorm.DB, batch int64) (more bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var table string
+		var maxID int64
+		// the other replicas backfill the other tables meanwhile
+		err := tx.Raw("SELECT table_name, max_id FROM events_keys_backfill ORDER BY table_name LIMIT 1 FOR UPDATE SKIP LOCKED").
+			Row().Scan(&table, &maxID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "get the backfill progress failed")
+		}
+		more = true
+
+		// the legacy table is dropped once all its records expire
+		if !eventsKeysBackfillTables[table] || !tx.Migrator().HasTable(table) {
+			return errors.WithStack(tx.Exec("DELETE FROM events_keys_backfill WHERE table_name = ?", table).Error)
+		}
+
+		minID := max(maxID-batch, 0)
+		if err := tx.Exec(fmt.Sprintf("INSERT INTO events_keys (idempotency_key, event_time) "+
+			"SELECT idempotency_key, max(event_time) FROM %s "+
+			"WHERE id > ? AND id <= ? AND idempotency_key IS NOT NULL AND event_time IS NOT NULL GROUP BY idempotency_key "+
+			"ON CONFLICT (idempotency_key) DO UPDATE SET event_time = GREATEST(events_keys.event_time, EXCLUDED.event_time)", table),
+			minID, maxID).Error; err != nil {
+			return errors.Wrapf(err, "backfill the idempotency keys of %s failed", table)
+		}
+		if minID > 0 {
+			return errors.WithStack(tx.Exec("UPDATE events_keys_backfill SET max_id = ? WHERE table_name = ?", minID, table).Error)
+		}
+		log.L.Info("idempotency keys backfilled", "table", table)
+		return errors.WithStack(tx.Exec("DELETE FROM events_keys_backfill WHERE table_name = ?", table).Error)
+	})
+	return more, err
+}
@@ -0,0 +1,204 @@
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	partitionPrefixDaily  = "events_d"
+	partitionPrefixWeekly = "events_w"
+	partitionDateLayout   = "20060102"
+)
+
+// partition is a range partition of the postgres events table on event_time,
+// the name tells the interval and the start day, e.g. events_d20241018 or events_w20241014
+type partition struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// newPartition returns the partition of the period containing t, the days start at 00:00 UTC and the weeks on Monday
+func newPartition(interval config.PartitionInterval, t time.Time) partition {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if interval == config.PartitionIntervalWeekly {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		return partition{Name: partitionPrefixWeekly + start.Format(partitionDateLayout), Start: start, End: start.AddDate(0, 0, 7)}
+	}
+	return partition{Name: partitionPrefixDaily + start.Format(partitionDateLayout), Start: start, End: start.AddDate(0, 0, 1)}
+}
+
+// parsePartition parses the partition by its name, false if the table is not a partition made by kubetrack
+func parsePartition(name string) (partition, bool) {
+	var interval config.PartitionInterval
+	var day string
+	switch {
+	case strings.HasPrefix(name, partitionPrefixDaily):
+		interval, day = config.PartitionIntervalDaily, strings.TrimPrefix(name, partitionPrefixDaily)
+	case strings.HasPrefix(name, partitionPrefixWeekly):
+		interval, day = config.PartitionIntervalWeekly, strings.TrimPrefix(name, partitionPrefixWeekly)
+	default:
+		return partition{}, false
+	}
+	start, err := time.Parse(partitionDateLayout, day)
+	if err != nil {
+		return partition{}, false
+	}
+	p := newPartition(interval, start)
+	return p, p.Name == name
+}
+
+func (p partition) overlaps(o partition) bool {
+	return p.Start.Before(o.End) && o.Start.Before(p.End)
+}
+
+// upcomingPartitions returns the partition of the current period and the premake ones after it
+func upcomingPartitions(interval config.PartitionInterval, now time.Time, premake int) []partition {
+	partitions := []partition{newPartition(interval, now)}
+	for range premake {
+		partitions = append(partitions, newPartition(interval, partitions[len(partitions)-1].End))
+	}
+	return partitions
+}
+
+// listPartitions lists the partitions of the events table made by kubetrack
+func listPartitions(db *gorm.DB) ([]partition, error) {
+	rows, err := db.Raw("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = 'events'::regclass").Rows()
+	if err != nil {
+		return nil, errors.Wrap(err, "list partitions failed")
+	}
+	defer rows.Close()
+
+	var partitions []partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "list partitions failed")
+		}
+		if p, ok := parsePartition(name); ok {
+			partitions = append(partitions, p)
+		}
+	}
+	return partitions, errors.Wrap(rows.Err(), "list partitions failed")
+}
+
+// createPartitions creates the partitions of the current and the upcoming periods,
+// the periods overlapping the existing partitions are skipped, e.g. after the interval is changed,
+// their records are stored in the default partition. The failed partitions are skipped, the first error is returned
+func createPartitions(db *gorm.DB, interval config.PartitionInterval, premake int, now time.Time) error {
+	existing, err := listPartitions(db)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+next:
+	for _, p := range upcomingPartitions(interval, now, premake) {
+		for _, e := range existing {
+			if p.overlaps(e) {
+				continue next
+			}
+		}
+		log.L.Info("creating partition", "partition", p.Name)
+		if err := createPartition(db, p); err != nil {
+			log.L.Error(err, "create partition failed, its records are stored in the default partition", "partition", p.Name)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "create partition %s failed", p.Name)
+			}
+		}
+	}
+	return firstErr
+}
+
+// createPartition creates the partition, the records of its range in the default partition, e.g. replayed from
+// the spool or with a skewed clock, would fail it, they're moved to the partition in the same transaction
+func createPartition(db *gorm.DB, p partition) error {
+	inRange, rangeVars := "event_time >= ? AND event_time < ?", []any{p.Start, p.End}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return execStatements(tx, []clause.Expr{
+			{SQL: fmt.Sprintf("CREATE TEMPORARY TABLE moved_events ON COMMIT DROP AS SELECT %s FROM events_default WITH NO DATA", eventsColumns)},
+			{SQL: fmt.Sprintf("INSERT INTO moved_events SELECT %s FROM events_default WHERE %s", eventsColumns, inRange), Vars: rangeVars},
+			{SQL: "DELETE FROM events_default WHERE " + inRange, Vars: rangeVars},
+			{SQL: fmt.Sprintf("CREATE TABLE %s PARTITION OF events FOR VALUES FROM ('%s') TO ('%s')",
+				p.Name, p.Start.Format(time.RFC3339), p.End.Format(time.RFC3339))},
+			{SQL: fmt.Sprintf("INSERT INTO events (%s) SELECT %s FROM moved_events", eventsColumns, eventsColumns)},
+		})
+	})
+}
+
+func execStatements(tx *gorm.DB, stmts []clause.Expr) error {
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt.SQL, stmt.Vars...).Error; err != nil {
+			return errors.Wrapf(err, "exec %q failed", stmt.SQL)
+		}
+	}
+	return nil
+}
+
+// the columns of the events table, the legacy table has them in another order
+const eventsColumns = "id, created_at, updated_at, cluster, event_time, source, event_type, api_version, kind, namespace, name, uid, " +
+	"idempotency_key, fields, message, severity, object, diff, json_patch"
+
+// dropExpiredPartitions drops the partitions entirely before the cutoff, the legacy table is dropped once all its
+// records expire, the exempted records are moved to the default partition before dropping them, where they're
+// deleted by the retention policies once they're no longer exempted
+func dropExpiredPartitions(db *gorm.DB, cutoff time.Time, exempt *retentionExemption) error {
+	partitions, err := listPartitions(db)
+	if err != nil {
+		return err
+	}
+	exempted, exemptedVars := exempt.cond()
+	for _, p := range partitions {
+		if p.End.After(cutoff) {
+			continue
+		}
+		log.L.Info("dropping expired partition", "partition", p.Name)
+		if err := dropTable(db, p.Name, true, exempted, exemptedVars); err != nil {
+			return errors.Wrapf(err, "drop partition %s failed", p.Name)
+		}
+	}
+
+	if !db.Migrator().HasTable("events_legacy") {
+		return nil
+	}
+	var latest sql.NullTime
+	if err := db.Raw("SELECT max(event_time) FROM events_legacy").Row().Scan(&latest); err != nil {
+		return errors.Wrap(err, "query the legacy table failed")
+	}
+	if latest.Valid && latest.Time.After(cutoff) {
+		return nil
+	}
+	log.L.Info("dropping expired legacy table", "table", "events_legacy")
+	return errors.Wrap(dropTable(db, "events_legacy", false, exempted, exemptedVars), "drop the legacy table failed")
+}
+
+// dropTable drops the partition or the legacy table, and inserts its exempted records back to the events table,
+// which are stored in the default partition as the range is no longer covered
+func dropTable(db *gorm.DB, table string, partition bool, exempted string, exemptedVars []any) error {
+	if exempted == "" {
+		return db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table)).Error
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		stmts := []clause.Expr{
+			{SQL: fmt.Sprintf("CREATE TEMPORARY TABLE exempted_events ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", eventsColumns, table)},
+			{SQL: fmt.Sprintf("INSERT INTO exempted_events SELECT %s FROM %s WHERE %s", eventsColumns, table, exempted), Vars: exemptedVars},
+			{SQL: fmt.Sprintf("DROP TABLE %s", table)},
+			{SQL: fmt.Sprintf("INSERT INTO events (%s) SELECT %s FROM exempted_events", eventsColumns, eventsColumns)},
+		}
+		if partition {
+			stmts = slices.Insert(stmts, 2, clause.Expr{SQL: fmt.Sprintf("ALTER TABLE events DETACH PARTITION %s", table)})
+		}
+		return execStatements(tx, stmts)
+	})
+}
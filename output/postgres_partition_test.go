@@ -0,0 +1,185 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/gormutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewPartition(t *testing.T) {
+	// early on Sunday in UTC+8, still Saturday in UTC
+	now := time.Date(2024, 10, 20, 3, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+
+	p := newPartition(config.PartitionIntervalDaily, now)
+	assert.Equal(t, "events_d20241019", p.Name)
+	assert.Equal(t, time.Date(2024, 10, 19, 0, 0, 0, 0, time.UTC), p.Start)
+	assert.Equal(t, time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC), p.End)
+
+	p = newPartition(config.PartitionIntervalWeekly, now)
+	assert.Equal(t, "events_w20241014", p.Name)
+	assert.Equal(t, time.Date(2024, 10, 14, 0, 0, 0, 0, time.UTC), p.Start)
+	assert.Equal(t, time.Date(2024, 10, 21, 0, 0, 0, 0, time.UTC), p.End)
+}
+
+func TestParsePartition(t *testing.T) {
+	p, ok := parsePartition("events_w20241014")
+	assert.True(t, ok)
+	assert.Equal(t, newPartition(config.PartitionIntervalWeekly, time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC)), p)
+
+	for _, name := range []string{"events_default", "events_legacy", "events_d2024101", "events_w20241015"} {
+		_, ok := parsePartition(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestUpcomingPartitions(t *testing.T) {
+	now := time.Date(2024, 10, 31, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for _, p := range upcomingPartitions(config.PartitionIntervalDaily, now, 2) {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"events_d20241031", "events_d20241101", "events_d20241102"}, names)
+
+	// the weekly partitions overlap the daily ones of the same week after the interval is changed
+	weekly := upcomingPartitions(config.PartitionIntervalWeekly, now, 1)
+	assert.Len(t, weekly, 2)
+	assert.True(t, weekly[0].overlaps(newPartition(config.PartitionIntervalDaily, now)))
+	assert.False(t, weekly[1].overlaps(newPartition(config.PartitionIntervalDaily, now)))
+}
+
+// tempPostgresDB opens the postgres database of KUBETRACK_TEST_POSTGRES_DSN in a temporary schema and migrates it,
+// the test is skipped if it's not set
+func tempPostgresDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("KUBETRACK_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("KUBETRACK_TEST_POSTGRES_DSN not set")
+	}
+	db, err := openDB("postgres", dsn, config.DBPool{})
+	require.NoError(t, err)
+	schema := fmt.Sprintf("kubetrack_test_%d", time.Now().UnixNano())
+	require.NoError(t, db.Exec("CREATE SCHEMA "+schema).Error)
+	t.Cleanup(func() {
+		_ = db.Exec("DROP SCHEMA " + schema + " CASCADE").Error
+		closeDB(db)
+	})
+
+	// every connection of the pool uses the schema
+	switch {
+	case strings.Contains(dsn, "://") && strings.Contains(dsn, "?"):
+		dsn += "&search_path=" + schema
+	case strings.Contains(dsn, "://"):
+		dsn += "?search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+	schemaDB, err := openDB("postgres", dsn, config.DBPool{})
+	require.NoError(t, err)
+	t.Cleanup(func() { closeDB(schemaDB) })
+	require.NoError(t, migrateEvents(schemaDB, false, nil))
+	return schemaDB
+}
+
+func TestPostgresOutput_removeExpiredEvents(t *testing.T) {
+	db := tempPostgresDB(t)
+	now := time.Now()
+	lo := &PostgresOutput{
+		ktconfig: &config.KubeTrackConfiguration{Cluster: "test"},
+		conf:     &config.OutputPostgres{TTLDays: 7, PartitionInterval: config.PartitionIntervalDaily},
+	}
+	lo.retention = retentionPolicies(lo.ktconfig, lo.conf.TTLDays)
+	require.NoError(t, createPartitions(db, lo.conf.PartitionInterval, 1, now))
+
+	record := func(name, namespace string, days int) OutputStruct {
+		return OutputStruct{
+			EventTime:      now.AddDate(0, 0, -days),
+			ObjectRef:      corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: name, UID: types.UID(name)},
+			Source:         SourceTypeGeneral,
+			EventType:      EventTypeUpdate,
+			IdempotencyKey: name,
+		}
+	}
+	// the old records are stored in the default partition, the duplicated key is ignored
+	require.NoError(t, insertEvents(db, "test", record("expired", "default", 10), record("held", "legal", 10), record("recent", "default", 0)))
+	require.NoError(t, insertEvents(db, "test", record("expired", "default", 10)))
+	names := func(table string) (names []string) {
+		require.NoError(t, db.Table(table).Order("name").Pluck("name", &names).Error)
+		return
+	}
+	assert.Equal(t, []string{"expired", "held"}, names("events_default"))
+
+	// the records of the range of the new partition are moved from the default partition
+	old := newPartition(lo.conf.PartitionInterval, now.AddDate(0, 0, -10))
+	require.NoError(t, createPartition(db, old))
+	assert.Empty(t, names("events_default"))
+	assert.Equal(t, []string{"expired", "held"}, names(old.Name))
+
+	run := func(holds []LegalHold) {
+		exempt := newRetentionExemption(db.Dialector.Name(), holds, lo.ktconfig)
+		require.NoError(t, gormutils.WithConn(db, func(tx *gorm.DB) error {
+			return lo.removeExpiredEvents(tx, exempt, now)
+		}))
+	}
+
+	// the held record is moved to the default partition before dropping the expired partition
+	run([]LegalHold{{ID: "hold", Namespace: "legal"}})
+	partitions, err := listPartitions(db)
+	require.NoError(t, err)
+	assert.NotContains(t, partitions, old)
+	assert.Equal(t, []string{"held"}, names("events_default"))
+	assert.Equal(t, []string{"held", "recent"}, names("events"))
+
+	// the record released from the hold is deleted from the default partition
+	run(nil)
+	assert.Empty(t, names("events_default"))
+	assert.Equal(t, []string{"recent"}, names("events"))
+
+	var keys []string
+	require.NoError(t, db.Table("events_keys").Order("idempotency_key").Pluck("idempotency_key", &keys).Error)
+	assert.Equal(t, []string{"held", "recent"}, keys)
+}
+
+func TestBackfillEventsKeys(t *testing.T) {
+	db := tempPostgresDB(t)
+	now := time.Now()
+	require.NoError(t, createPartitions(db, config.PartitionIntervalDaily, 1, now))
+
+	// the records written before the events_keys table
+	var events []*Events
+	for i := range 5 {
+		e := NewEvents("test", OutputStruct{EventTime: now, Source: SourceTypeGeneral, IdempotencyKey: fmt.Sprintf("key-%d", i)})
+		events = append(events, e)
+	}
+	events = append(events, NewEvents("test", OutputStruct{EventTime: now, Source: SourceTypeGeneral}))
+	require.NoError(t, db.Create(events).Error)
+	var maxID int64
+	require.NoError(t, db.Raw("SELECT max(id) FROM events").Row().Scan(&maxID))
+	require.NoError(t, db.Exec("INSERT INTO events_keys_backfill (table_name, max_id) VALUES ('events', ?), ('events_legacy', 1)", maxID).Error)
+
+	require.NoError(t, backfillEventsKeys(context.Background(), db, 2))
+
+	var keys []string
+	require.NoError(t, db.Table("events_keys").Order("idempotency_key").Pluck("idempotency_key", &keys).Error)
+	assert.Equal(t, []string{"key-0", "key-1", "key-2", "key-3", "key-4"}, keys)
+	var pending int64
+	require.NoError(t, db.Table("events_keys_backfill").Count(&pending).Error)
+	assert.Zero(t, pending)
+
+	// the records emitted again are not inserted
+	require.NoError(t, insertPostgresEvents(db, []*Events{
+		NewEvents("test", OutputStruct{EventTime: now.Add(time.Second), Source: SourceTypeGeneral, IdempotencyKey: "key-0"}),
+	}))
+	var count int64
+	require.NoError(t, db.Model(&Events{}).Count(&count).Error)
+	assert.Equal(t, int64(6), count)
+}
@@ -1,31 +1,59 @@
 package output
 
 import (
-	"fmt"
+	"cmp"
+	"context"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/major1201/kubetrack/config"
 	"github.com/major1201/kubetrack/gormutils"
 	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PostgresOutput struct {
 	ktconfig *config.KubeTrackConfiguration
+	conn     *dbConn
+	batcher  *batcher[OutputStruct]
 	conf     *config.OutputPostgres
+
+	retention []retentionPolicy
+
+	// stops the backfill of the idempotency keys on close
+	ctx         context.Context
+	cancel      context.CancelFunc
+	backfilling atomic.Bool
 }
 
 func NewPostgresOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputPostgres) *PostgresOutput {
 	if conf == nil {
 		return nil
 	}
+	if conf.DSN == "" {
+		log.L.Error(nil, "postgres dsn not set")
+		os.Exit(1)
+	}
+	conf.PartitionInterval = cmp.Or(conf.PartitionInterval, config.PartitionIntervalDaily)
+	if conf.PartitionInterval != config.PartitionIntervalDaily && conf.PartitionInterval != config.PartitionIntervalWeekly {
+		log.L.Error(nil, "unknown postgres partition interval", "partitionInterval", conf.PartitionInterval)
+		os.Exit(1)
+	}
+	conf.PremakePartitions = cmp.Or(conf.PremakePartitions, 3)
 	out := &PostgresOutput{
 		ktconfig: ktconfig,
 		conf:     conf,
 	}
-	out.initDB()
-	out.migrate()
-	out.initCleanupJob()
+	out.ctx, out.cancel = context.WithCancel(context.Background())
+	out.conn = newDBConn(out.Name(), out.connect)
+	out.initPartitionJob()
+	out.batcher = newDBBatcher(out.Name(), out.conn, ktconfig.Cluster, conf.FlushSize, conf.FlushInterval.Duration)
 
 	return out
 }
@@ -35,68 +63,188 @@ func (lo *PostgresOutput) Name() string {
 }
 
 func (lo *PostgresOutput) Write(out OutputStruct) error {
-	ev := &Events{
-		Cluster:   lo.ktconfig.Cluster,
-		EventTime: out.EventTime,
-		Source:    string(out.Source),
-		EventType: string(out.EventType),
-
-		APIVersion: out.ObjectRef.APIVersion,
-		Kind:       out.ObjectRef.Kind,
-		Namespace:  out.ObjectRef.Namespace,
-		Name:       out.ObjectRef.Name,
-		UID:        string(out.ObjectRef.UID),
-
-		Fields:  gormutils.MustToJsonb(out.Fields),
-		Message: out.Message,
-
-		Object:    gormutils.MustToJsonb(out.Object),
-		Diff:      out.Diff,
-		JsonPatch: gormutils.MustToJsonb(out.JsonPatch),
-	}
-	return gormutils.Save(ev)
+	if lo.batcher != nil {
+		return lo.batcher.Add(out)
+	}
+	db, err := lo.conn.DB()
+	if err != nil {
+		return err
+	}
+	return insertEvents(db, lo.ktconfig.Cluster, out)
 }
 
-func (lo *PostgresOutput) initDB() {
-	os.Setenv(gormutils.EnvDBDriver, "postgres")
-	os.Setenv(gormutils.EnvDBConnection, lo.conf.DSN)
-	// try db connection
-	sqlDB, err := gormutils.GetDB().DB()
-	if err != nil {
-		log.L.Error(err, "get sql db failed")
-		os.Exit(1)
+// insertPostgresEvents claims the idempotency keys in the events_keys table, and inserts the records
+// whose keys are claimed in the same transaction, the records without a key are always inserted
+func insertPostgresEvents(db *gorm.DB, events []*Events) error {
+	var values []string
+	var vars []any
+	for _, e := range events {
+		if e.IdempotencyKey != nil {
+			values, vars = append(values, "(?, ?)"), append(vars, *e.IdempotencyKey, e.EventTime)
+		}
 	}
-	if err = sqlDB.Ping(); err != nil {
-		log.L.Error(err, "db connect failed")
-		os.Exit(1)
+	if len(values) == 0 {
+		return errors.WithStack(db.Create(events).Error)
+	}
+
+	return errors.WithStack(db.Transaction(func(tx *gorm.DB) error {
+		rows, err := tx.Raw("INSERT INTO events_keys (idempotency_key, event_time) VALUES "+strings.Join(values, ", ")+
+			" ON CONFLICT DO NOTHING RETURNING idempotency_key", vars...).Rows()
+		if err != nil {
+			return errors.Wrap(err, "claim idempotency keys failed")
+		}
+		defer rows.Close()
+		claimed := map[string]bool{}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return errors.Wrap(err, "claim idempotency keys failed")
+			}
+			claimed[key] = true
+		}
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "claim idempotency keys failed")
+		}
+
+		// a key is claimed once even if the batch has it more than once
+		events = slices.DeleteFunc(events, func(e *Events) bool {
+			if e.IdempotencyKey == nil {
+				return false
+			}
+			if !claimed[*e.IdempotencyKey] {
+				return true
+			}
+			delete(claimed, *e.IdempotencyKey)
+			return false
+		})
+		if len(events) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(events).Error
+	}))
+}
+
+// Close stops the backfill and inserts the pending records
+func (lo *PostgresOutput) Close() error {
+	lo.cancel()
+	if lo.batcher != nil {
+		return lo.batcher.Close()
 	}
+	return nil
 }
 
-func (lo *PostgresOutput) migrate() {
-	log.L.Info("migrating postgres")
+// open opens the database without migrating it
+func (lo *PostgresOutput) open() (*gorm.DB, error) {
+	return openDB("postgres", lo.conf.DSN, lo.conf.DBPool)
+}
 
-	if err := gormutils.GetDB().AutoMigrate(&Events{}); err != nil {
-		log.L.Error(err, "migrate error")
-		os.Exit(1)
+// connect opens and migrates the database, and creates the upcoming partitions,
+// the records are stored in the default partition until the partition job creates the failed ones
+func (lo *PostgresOutput) connect() (*gorm.DB, error) {
+	db, err := lo.open()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateEvents(db, false, nil); err != nil {
+		closeDB(db)
+		return nil, err
 	}
+	if err := createPartitions(db, lo.conf.PartitionInterval, lo.conf.PremakePartitions, time.Now()); err != nil {
+		log.L.Error(err, "create partitions failed, retrying in the partition job")
+	}
+	go lo.backfillKeys(db)
+	return db, nil
 }
 
-func (lo *PostgresOutput) initCleanupJob() {
-	if lo.conf.TTLDays <= 0 {
+// backfillKeys runs backfillEventsKeys unless it's running already
+func (lo *PostgresOutput) backfillKeys(db *gorm.DB) {
+	if !lo.backfilling.CompareAndSwap(false, true) {
 		return
 	}
+	defer lo.backfilling.Store(false)
+	if err := backfillEventsKeys(lo.ctx, db, eventsKeysBackfillBatch); err != nil && lo.ctx.Err() == nil {
+		log.L.Error(err, "backfill the idempotency keys failed, retrying in the partition job")
+	}
+}
+
+func (lo *PostgresOutput) initPartitionJob() {
+	lo.retention = retentionPolicies(lo.ktconfig, lo.conf.TTLDays)
 
 	cj := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger), cron.Recover(cron.DefaultLogger)))
-	if _, err := cj.AddFunc("0 * * * *", lo.doCleanupJob); err != nil {
+	if _, err := cj.AddFunc("0 * * * *", lo.doPartitionJob); err != nil {
 		panic(err)
 	}
 	cj.Start()
-	log.L.Info("postgres cleanup job started, will run every hour", "ttl", lo.conf.TTLDays)
+	log.L.Info("postgres partition job started, will run every hour", "interval", lo.conf.PartitionInterval, "ttl", lo.conf.TTLDays)
 }
 
-func (lo *PostgresOutput) doCleanupJob() {
-	log.L.Info("running cleanup job", "ttlDays", lo.conf.TTLDays)
-	if err := gormutils.Delete(&Events{}, fmt.Sprintf("created_at < now() - INTERVAL '%d days'", lo.conf.TTLDays)); err != nil {
-		log.L.Error(err, "cron: delete data failed")
+// doPartitionJob creates the upcoming partitions and removes the expired records,
+// the records of the longest retention are removed by dropping the partitions instead of deleting them
+func (lo *PostgresOutput) doPartitionJob() {
+	log.L.Info("running partition job", "ttlDays", lo.conf.TTLDays)
+	db, err := lo.conn.DB()
+	if err != nil {
+		log.L.Error(err, "cron: skip partition job")
+		return
+	}
+	now := time.Now()
+	if err := createPartitions(db, lo.conf.PartitionInterval, lo.conf.PremakePartitions, now); err != nil {
+		log.L.Error(err, "cron: create partitions failed")
+	}
+	go lo.backfillKeys(db)
+	if !hasRetention(lo.retention) {
+		return
+	}
+	// nothing is removed if the holds are unknown
+	holds, err := syncHolds(db, lo.ktconfig)
+	if err != nil {
+		log.L.Error(err, "cron: skip removing the expired records")
+		return
+	}
+	exempt := newRetentionExemption(db.Dialector.Name(), holds, lo.ktconfig)
+	// the kept records are looked up once in a temporary table of the connection
+	if err := gormutils.WithConn(db, func(tx *gorm.DB) error {
+		return lo.removeExpiredEvents(tx, exempt, now)
+	}); err != nil {
+		log.L.Error(err, "cron: remove expired records failed")
+	}
+}
+
+// the last records of the objects are looked up in both tables until the legacy table is dropped
+const allEventsTable = "(SELECT id, cluster, event_time, source, event_type, api_version, kind, namespace, uid, object FROM events " +
+	"UNION ALL SELECT id, cluster, event_time, source, event_type, api_version, kind, namespace, uid, object FROM events_legacy)"
+
+// removeExpiredEvents drops the expired partitions and deletes the expired records, the exempted records are kept
+func (lo *PostgresOutput) removeExpiredEvents(tx *gorm.DB, exempt *retentionExemption, now time.Time) error {
+	from := "events"
+	if tx.Migrator().HasTable("events_legacy") {
+		from = allEventsTable
+	}
+	if err := exempt.saveKept(tx, from); err != nil {
+		return err
+	}
+	defer exempt.dropKept(tx)
+
+	// the partitions are kept if the records matching no policy never expire
+	if lo.conf.TTLDays > 0 {
+		maxTTLDays := 0
+		for _, p := range lo.retention {
+			maxTTLDays = max(maxTTLDays, p.TTLDays)
+		}
+		cutoff := now.AddDate(0, 0, -maxTTLDays)
+		if err := dropExpiredPartitions(tx, cutoff, exempt); err != nil {
+			log.L.Error(err, "cron: drop expired partitions failed")
+		}
+		// the keys of the remaining records are kept, so they're never inserted again
+		result := tx.Exec("DELETE FROM events_keys k WHERE k.event_time < ? AND NOT EXISTS "+
+			"(SELECT 1 FROM events e WHERE e.idempotency_key = k.idempotency_key)", cutoff)
+		if result.Error != nil {
+			log.L.Error(result.Error, "cron: delete expired idempotency keys failed")
+		} else {
+			log.L.Info("expired idempotency keys deleted", "count", result.RowsAffected)
+		}
 	}
+	// the expired records left, in the default partition or the partition of the cutoff, are deleted by every policy,
+	// the dropped partitions are pruned from the deletes
+	return deleteExpiredEvents(tx, lo.retention, exempt, now)
 }
@@ -0,0 +1,157 @@
+package output
+
+import (
+	"cmp"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultQueueCapacity = 1000
+	defaultQueueWorkers  = 1
+
+	defaultQueueBlockTimeout = time.Second
+)
+
+// ErrQueueFull is returned by Write when the record is dropped by the dropNewest or the block policy
+var ErrQueueFull = errors.New("output queue is full, record dropped")
+
+// ErrOutputClosed is returned by Write after the output is closed
+var ErrOutputClosed = errors.New("output closed")
+
+// QueuedOutput delivers the records to the wrapped output asynchronously through a bounded queue
+type QueuedOutput struct {
+	output       Output
+	overflow     config.OverflowPolicy
+	blockTimeout time.Duration
+	queue        chan OutputStruct
+	labels       []string
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup // the records enqueued but not written yet
+}
+
+// NewQueuedOutput wraps the output with a queue, index is the position of the output in the config
+func NewQueuedOutput(index int, out Output, conf *config.OutputQueue) *QueuedOutput {
+	if conf == nil {
+		conf = &config.OutputQueue{}
+	}
+	overflow := cmp.Or(conf.Overflow, config.OverflowPolicyDropOldest)
+	switch overflow {
+	case config.OverflowPolicyBlock, config.OverflowPolicyDropOldest, config.OverflowPolicyDropNewest:
+	default:
+		log.L.Error(nil, "unknown queue overflow policy", "output", out.Name(), "overflow", overflow)
+		os.Exit(1)
+	}
+
+	capacity := cmp.Or(conf.Capacity, defaultQueueCapacity)
+	qo := &QueuedOutput{
+		output:       out,
+		overflow:     overflow,
+		blockTimeout: cmp.Or(conf.BlockTimeout.Duration, defaultQueueBlockTimeout),
+		queue:        make(chan OutputStruct, capacity),
+		labels:       []string{out.Name(), strconv.Itoa(index)},
+	}
+	metrics.OutputQueueCapacity.WithLabelValues(qo.labels...).Set(float64(capacity))
+	metrics.OutputQueueDepth.WithLabelValues(qo.labels...).Set(0)
+
+	for range cmp.Or(conf.Workers, defaultQueueWorkers) {
+		go qo.work()
+	}
+	return qo
+}
+
+func (qo *QueuedOutput) Name() string {
+	return qo.output.Name()
+}
+
+// Write enqueues the record, the error of the wrapped output is logged by the workers
+func (qo *QueuedOutput) Write(out OutputStruct) error {
+	defer qo.updateDepth()
+
+	qo.mu.Lock()
+	if qo.closed {
+		qo.mu.Unlock()
+		return ErrOutputClosed
+	}
+	qo.pending.Add(1)
+	qo.mu.Unlock()
+
+	switch qo.overflow {
+	case config.OverflowPolicyDropNewest:
+		select {
+		case qo.queue <- out:
+			return nil
+		default:
+			qo.pending.Done()
+			metrics.OutputRecordsDropped.WithLabelValues(append(qo.labels, string(qo.overflow))...).Inc()
+			return ErrQueueFull
+		}
+	case config.OverflowPolicyDropOldest:
+		for {
+			select {
+			case qo.queue <- out:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-qo.queue:
+				qo.pending.Done()
+				metrics.OutputRecordsDropped.WithLabelValues(append(qo.labels, string(qo.overflow))...).Inc()
+				log.L.Error(ErrQueueFull, "dropped the oldest record", "name", qo.Name(), "kind", dropped.ObjectRef.Kind, "namespace", dropped.ObjectRef.Namespace, "objName", dropped.ObjectRef.Name)
+			default:
+			}
+		}
+	default:
+		// the informer is blocked, so the other outputs are, don't wait forever
+		timer := time.NewTimer(qo.blockTimeout)
+		defer timer.Stop()
+		select {
+		case qo.queue <- out:
+			return nil
+		case <-timer.C:
+			qo.pending.Done()
+			metrics.OutputRecordsDropped.WithLabelValues(append(qo.labels, string(qo.overflow))...).Inc()
+			return ErrQueueFull
+		}
+	}
+}
+
+// Close stops accepting the records, waits for the queued ones to be written, then closes the wrapped output
+func (qo *QueuedOutput) Close() error {
+	qo.mu.Lock()
+	qo.closed = true
+	qo.mu.Unlock()
+
+	qo.pending.Wait()
+	return CloseOutput(qo.output)
+}
+
+func (qo *QueuedOutput) work() {
+	for out := range qo.queue {
+		qo.updateDepth()
+		qo.write(out)
+		qo.pending.Done()
+	}
+}
+
+func (qo *QueuedOutput) write(out OutputStruct) {
+	if err := qo.output.Write(out); err != nil {
+		metrics.OutputWriteErrors.WithLabelValues(qo.labels...).Inc()
+		log.L.Error(err, "writing output failed", "name", qo.Name())
+		return
+	}
+	metrics.OutputRecordsWritten.WithLabelValues(qo.labels...).Inc()
+}
+
+func (qo *QueuedOutput) updateDepth() {
+	metrics.OutputQueueDepth.WithLabelValues(qo.labels...).Set(float64(len(qo.queue)))
+}
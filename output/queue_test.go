@@ -0,0 +1,108 @@
+package output
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type blockingOutput struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	written []string
+}
+
+func (bo *blockingOutput) Name() string {
+	return "blocking"
+}
+
+func (bo *blockingOutput) Write(out OutputStruct) error {
+	<-bo.release
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	bo.written = append(bo.written, out.ObjectRef.Name)
+	return nil
+}
+
+func (bo *blockingOutput) Written() []string {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	return append([]string(nil), bo.written...)
+}
+
+func TestQueuedOutput(t *testing.T) {
+	record := func(name string) OutputStruct {
+		return OutputStruct{ObjectRef: corev1.ObjectReference{Name: name}}
+	}
+
+	for _, tc := range []struct {
+		overflow config.OverflowPolicy
+		expected []string
+	}{
+		{config.OverflowPolicyDropNewest, []string{"a", "b", "c"}},
+		{config.OverflowPolicyDropOldest, []string{"a", "c", "d"}},
+	} {
+		t.Run(string(tc.overflow), func(t *testing.T) {
+			bo := &blockingOutput{release: make(chan struct{})}
+			qo := NewQueuedOutput(0, bo, &config.OutputQueue{Capacity: 2, Overflow: tc.overflow})
+
+			// the worker takes a and blocks on it
+			assert.NoError(t, qo.Write(record("a")))
+			assert.Eventually(t, func() bool { return len(qo.queue) == 0 }, time.Second, time.Millisecond)
+
+			assert.NoError(t, qo.Write(record("b")))
+			assert.NoError(t, qo.Write(record("c")))
+			err := qo.Write(record("d"))
+			if tc.overflow == config.OverflowPolicyDropNewest {
+				assert.ErrorIs(t, err, ErrQueueFull)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// close waits for the queued records
+			close(bo.release)
+			assert.NoError(t, qo.Close())
+			assert.Equal(t, tc.expected, bo.Written())
+			assert.ErrorIs(t, qo.Write(record("e")), ErrOutputClosed)
+		})
+	}
+}
+
+func TestQueuedOutput_stalledOutput(t *testing.T) {
+	const n = 100
+	for _, overflow := range []config.OverflowPolicy{"", config.OverflowPolicyDropNewest, config.OverflowPolicyBlock} {
+		t.Run(string(overflow), func(t *testing.T) {
+			stalled := &blockingOutput{release: make(chan struct{})}
+			defer close(stalled.release)
+			healthy := &recordingOutput{}
+			outputs := []Output{
+				NewQueuedOutput(0, stalled, &config.OutputQueue{Capacity: 10, Overflow: overflow, BlockTimeout: metav1.Duration{Duration: time.Millisecond}}),
+				NewQueuedOutput(1, healthy, &config.OutputQueue{Capacity: n, Overflow: overflow}),
+			}
+
+			// the handlers write every record to the outputs in turn
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := range n {
+					for _, out := range outputs {
+						_ = out.Write(OutputStruct{ObjectRef: corev1.ObjectReference{Name: strconv.Itoa(i)}})
+					}
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the stalled output blocks the others")
+			}
+			assert.Eventually(t, func() bool { return healthy.Len() == n }, time.Second, time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package output
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisStream  = "kubetrack"
+	defaultRedisMaxLen  = 100000
+	defaultRedisTimeout = 10 * time.Second
+)
+
+type RedisOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputRedis
+	client   *redis.Client
+}
+
+func NewRedisOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputRedis) *RedisOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.Addr == "" {
+		log.L.Error(nil, "redis addr not set")
+		os.Exit(1)
+	}
+	tlsConf, err := newTLSConfig(conf.TLS)
+	if err != nil {
+		log.L.Error(err, "init redis tls config failed")
+		os.Exit(1)
+	}
+
+	timeout := cmp.Or(conf.Timeout.Duration, defaultRedisTimeout)
+	client := redis.NewClient(&redis.Options{
+		Addr:         conf.Addr,
+		Username:     conf.Username,
+		Password:     conf.Password,
+		DB:           conf.DB,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		TLSConfig:    tlsConf,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.L.Error(err, "connect to redis failed", "addr", conf.Addr)
+		os.Exit(1)
+	}
+	log.L.Info("redis output initialized", "addr", conf.Addr, "stream", cmp.Or(conf.Stream, defaultRedisStream))
+
+	return &RedisOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+}
+
+func (ro *RedisOutput) Name() string {
+	return "redis"
+}
+
+func (ro *RedisOutput) Write(out OutputStruct) error {
+	data, err := json.Marshal(out)
+	if err != nil {
+		return errors.Wrap(err, "marshal output struct failed")
+	}
+
+	args := &redis.XAddArgs{
+		Stream: cmp.Or(ro.conf.Stream, defaultRedisStream),
+		Values: []any{
+			"cluster", ro.ktconfig.Cluster,
+			"source", string(out.Source),
+			"event_type", string(out.EventType),
+			"data", data,
+		},
+	}
+	if maxLen := cmp.Or(ro.conf.MaxLen, defaultRedisMaxLen); maxLen > 0 {
+		args.MaxLen = maxLen
+		args.Approx = !ro.conf.ExactTrim
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(ro.conf.Timeout.Duration, defaultRedisTimeout))
+	defer cancel()
+	return errors.Wrap(ro.client.XAdd(ctx, args).Err(), "redis xadd failed")
+}
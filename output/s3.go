@@ -0,0 +1,167 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultS3FlushSize     = 10000
+	defaultS3FlushInterval = 5 * time.Minute
+)
+
+type S3Output struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputS3
+	client   *minio.Client
+	batcher  *batcher[OutputStruct]
+}
+
+func NewS3Output(ktconfig *config.KubeTrackConfiguration, conf *config.OutputS3) *S3Output {
+	if conf == nil {
+		return nil
+	}
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		log.L.Error(nil, "s3 endpoint or bucket not set")
+		os.Exit(1)
+	}
+
+	client, err := newS3Client(conf)
+	if err != nil {
+		log.L.Error(err, "init s3 client failed")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, conf.Bucket)
+	if err != nil {
+		log.L.Error(err, "check s3 bucket failed", "bucket", conf.Bucket)
+		os.Exit(1)
+	}
+	if !exists {
+		log.L.Error(nil, "s3 bucket not found", "bucket", conf.Bucket)
+		os.Exit(1)
+	}
+
+	out := &S3Output{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+	out.batcher = newBatcher(out.Name(), cmp.Or(conf.FlushSize, defaultS3FlushSize), cmp.Or(conf.FlushInterval.Duration, defaultS3FlushInterval), out.upload)
+	log.L.Info("s3 output initialized", "endpoint", conf.Endpoint, "bucket", conf.Bucket)
+
+	return out
+}
+
+func newS3Client(conf *config.OutputS3) (*minio.Client, error) {
+	transport, err := minio.DefaultTransport(!conf.Insecure)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conf.TLS != nil {
+		tlsConfig, err := newTLSConfig(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	bucketLookup := minio.BucketLookupAuto
+	if conf.PathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(conf.AccessKeyID, conf.SecretAccessKey, ""),
+		Secure:       !conf.Insecure,
+		Region:       conf.Region,
+		BucketLookup: bucketLookup,
+		Transport:    transport,
+	})
+	return client, errors.WithStack(err)
+}
+
+func (so *S3Output) Name() string {
+	return "s3"
+}
+
+func (so *S3Output) Write(out OutputStruct) error {
+	return so.batcher.Add(out)
+}
+
+// Close uploads the pending records
+func (so *S3Output) Close() error {
+	return so.batcher.Close()
+}
+
+func (so *S3Output) handleFailed(fn func(records []OutputStruct)) bool {
+	so.batcher.handleFailed(fn)
+	return true
+}
+
+// upload puts the records into objects by the hour of the event time
+func (so *S3Output) upload(outs []OutputStruct) error {
+	hours := make(map[time.Time][]OutputStruct)
+	for _, out := range outs {
+		hour := out.EventTime.UTC().Truncate(time.Hour)
+		hours[hour] = append(hours[hour], out)
+	}
+
+	keys := make([]time.Time, 0, len(hours))
+	for hour := range hours {
+		keys = append(keys, hour)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Before(keys[j]) })
+
+	for _, hour := range keys {
+		if err := so.putObject(hour, hours[hour]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (so *S3Output) putObject(hour time.Time, outs []OutputStruct) error {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+	for _, out := range outs {
+		if err := enc.Encode(NewDocument(so.ktconfig.Cluster, out)); err != nil {
+			return errors.Wrap(err, "encode document failed")
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "gzip documents failed")
+	}
+
+	key := path.Join(so.conf.Prefix, cmp.Or(so.ktconfig.Cluster, "default"), hour.Format("2006/01/02/15"),
+		fmt.Sprintf("%d-%s.jsonl.gz", time.Now().UnixNano(), uuid.NewString()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	_, err := so.client.PutObject(ctx, so.conf.Bucket, key, buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "put s3 object failed, key=%s, records=%d", key, len(outs))
+	}
+	log.L.Info("s3 object uploaded", "key", key, "records", len(outs))
+	return nil
+}
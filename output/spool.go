@@ -0,0 +1,464 @@
+package output
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSpoolDir           = "/var/lib/kubetrack/spool"
+	defaultSpoolSegmentSize   = 64 << 20
+	defaultSpoolMaxSize       = 1 << 30
+	defaultSpoolFsyncInterval = time.Second
+	defaultSpoolRetryInterval = 5 * time.Second
+
+	spoolSegmentExt    = ".wal"
+	spoolCursorFile    = "cursor"
+	spoolRecordHeadLen = 8 // 4 bytes length + 4 bytes crc32 of the payload
+)
+
+type spoolSegment struct {
+	seq  int64
+	size int64
+}
+
+// SpooledOutput persists the records which the wrapped output failed to write in segment files,
+// and replays them in order once the output recovers. The records are delivered at least once,
+// a few records may be replayed again after a crash. The batched outputs hand the whole failed batches over,
+// a replayed record failed with its batch is spooled again after the records spooled meanwhile.
+type SpooledOutput struct {
+	output Output
+	conf   *config.OutputSpool
+	dir    string
+	labels []string
+
+	mu          sync.Mutex
+	segments    []*spoolSegment // oldest first, the last one is being written, the cursor is always in the first one
+	writer      *os.File
+	reader      *os.File
+	readerSeq   int64
+	readSeq     int64
+	readOff     int64
+	dirty       bool
+	cursorDirty bool
+
+	notify chan struct{}
+}
+
+// NewSpooledOutput wraps the output with a disk spool, index is the position of the output in the config
+func NewSpooledOutput(index int, out Output, conf *config.OutputSpool) *SpooledOutput {
+	if conf == nil {
+		return nil
+	}
+	switch conf.Fsync {
+	case "", config.FsyncPolicyAlways, config.FsyncPolicyInterval, config.FsyncPolicyNever:
+	default:
+		log.L.Error(nil, "unknown spool fsync policy", "output", out.Name(), "fsync", conf.Fsync)
+		os.Exit(1)
+	}
+
+	so := &SpooledOutput{
+		output: out,
+		conf:   conf,
+		dir:    filepath.Join(cmp.Or(conf.Dir, defaultSpoolDir), fmt.Sprintf("%d-%s", index, out.Name())),
+		labels: []string{out.Name(), strconv.Itoa(index)},
+		notify: make(chan struct{}, 1),
+	}
+	if err := so.open(); err != nil {
+		log.L.Error(err, "open spool failed", "dir", so.dir)
+		os.Exit(1)
+	}
+	if so.pending() {
+		log.L.Info("replaying spooled records", "name", so.Name(), "dir", so.dir)
+		so.signal()
+	}
+	// the batched outputs hand the whole failed batches, including the ones of the timed flushes, to the spool
+	if h, ok := out.(failedHandler); ok {
+		h.handleFailed(so.spoolFailed)
+	}
+
+	go so.replay()
+	if so.conf.Fsync != config.FsyncPolicyAlways {
+		go so.syncLoop()
+	}
+	return so
+}
+
+func (so *SpooledOutput) Name() string {
+	return so.output.Name()
+}
+
+// Write writes the record directly when nothing is spooled, otherwise the record is appended
+// to the spool to keep the order
+func (so *SpooledOutput) Write(out OutputStruct) error {
+	so.mu.Lock()
+	pending := so.pending()
+	so.mu.Unlock()
+
+	if !pending {
+		err := so.output.Write(out)
+		if err == nil || errors.As(err, new(handedOverError)) {
+			return nil
+		}
+		if isPermanent(err) {
+			return err
+		}
+		log.L.Error(err, "writing output failed, spooling the record", "name", so.Name())
+	}
+
+	so.mu.Lock()
+	err := so.append(out)
+	so.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "append record to spool failed")
+	}
+	so.signal()
+	return nil
+}
+
+// spoolFailed appends the records of the failed batch of the wrapped output
+func (so *SpooledOutput) spoolFailed(records []OutputStruct) {
+	log.L.Error(nil, "writing output failed, spooling the records of the batch", "name", so.Name(), "records", len(records))
+	so.mu.Lock()
+	for _, out := range records {
+		if err := so.append(out); err != nil {
+			log.L.Error(err, "append record to spool failed", "name", so.Name())
+		}
+	}
+	so.mu.Unlock()
+	so.signal()
+}
+
+// Close closes the wrapped output, whose failed records are spooled, then syncs the spool,
+// the spooled records are replayed after restarting
+func (so *SpooledOutput) Close() error {
+	err := CloseOutput(so.output)
+	if errors.As(err, new(handedOverError)) {
+		err = nil
+	}
+	so.mu.Lock()
+	if so.conf.Fsync != config.FsyncPolicyNever {
+		if err := so.writer.Sync(); err != nil {
+			log.L.Error(err, "sync spool segment failed", "name", so.Name())
+		}
+	}
+	so.saveCursor()
+	so.mu.Unlock()
+	return err
+}
+
+func (so *SpooledOutput) signal() {
+	select {
+	case so.notify <- struct{}{}:
+	default:
+	}
+}
+
+// replay delivers the spooled records one by one, and waits retryInterval after the output fails
+func (so *SpooledOutput) replay() {
+	retryInterval := cmp.Or(so.conf.RetryInterval.Duration, defaultSpoolRetryInterval)
+	for {
+		so.mu.Lock()
+		seq, off := so.readSeq, so.readOff
+		out, next, ok := so.next()
+		so.mu.Unlock()
+
+		if !ok {
+			<-so.notify
+			continue
+		}
+		if err := so.output.Write(out); isPermanent(err) {
+			log.L.Error(err, "dropped the spooled record rejected permanently", "name", so.Name())
+		} else if err != nil {
+			log.L.Error(err, "replaying spooled record failed", "name", so.Name())
+			time.Sleep(retryInterval)
+			// the record is spooled again with its failed batch
+			if !errors.As(err, new(handedOverError)) {
+				continue
+			}
+		}
+
+		so.mu.Lock()
+		// the segment may have been evicted while writing
+		if so.readSeq == seq && so.readOff == off {
+			so.readOff = next
+			so.advance()
+			so.cursorDirty = true
+			if so.conf.Fsync == config.FsyncPolicyAlways {
+				so.saveCursor()
+			}
+		}
+		so.mu.Unlock()
+	}
+}
+
+func (so *SpooledOutput) syncLoop() {
+	t := time.NewTicker(cmp.Or(so.conf.FsyncInterval.Duration, defaultSpoolFsyncInterval))
+	defer t.Stop()
+	for range t.C {
+		so.mu.Lock()
+		if so.dirty && so.conf.Fsync != config.FsyncPolicyNever {
+			if err := so.writer.Sync(); err != nil {
+				log.L.Error(err, "sync spool segment failed", "name", so.Name())
+			}
+		}
+		so.dirty = false
+		if so.cursorDirty {
+			so.saveCursor()
+		}
+		so.mu.Unlock()
+	}
+}
+
+// open loads the existing segments and the cursor, a new segment is always created for writing,
+// so a torn record at the end of the last run only affects the old segment
+func (so *SpooledOutput) open() error {
+	if err := os.MkdirAll(so.dir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	entries, err := os.ReadDir(so.dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		so.segments = append(so.segments, &spoolSegment{seq: seq, size: info.Size()})
+	}
+	slices.SortFunc(so.segments, func(a, b *spoolSegment) int { return cmp.Compare(a.seq, b.seq) })
+
+	if len(so.segments) > 0 {
+		so.readSeq = so.segments[0].seq
+		if data, err := os.ReadFile(filepath.Join(so.dir, spoolCursorFile)); err == nil {
+			var seq, off int64
+			if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &off); err == nil {
+				i := slices.IndexFunc(so.segments, func(s *spoolSegment) bool { return s.seq == seq })
+				if i >= 0 && off <= so.segments[i].size {
+					// the segments before the cursor are replayed already
+					for range i {
+						so.removeOldest()
+					}
+					so.readSeq, so.readOff = seq, off
+				}
+			}
+		}
+	}
+
+	var seq int64 = 1
+	if len(so.segments) > 0 {
+		seq = so.segments[len(so.segments)-1].seq + 1
+	}
+	if err := so.createSegment(seq); err != nil {
+		return err
+	}
+	if len(so.segments) == 1 {
+		so.readSeq, so.readOff = seq, 0
+	}
+	so.advance()
+	so.updateSize()
+	return nil
+}
+
+func (so *SpooledOutput) segmentPath(seq int64) string {
+	return filepath.Join(so.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+func (so *SpooledOutput) createSegment(seq int64) error {
+	f, err := os.OpenFile(so.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if so.writer != nil {
+		if err := so.writer.Sync(); err != nil {
+			log.L.Error(err, "sync spool segment failed", "name", so.Name())
+		}
+		_ = so.writer.Close()
+	}
+	so.writer = f
+	so.segments = append(so.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+func (so *SpooledOutput) pending() bool {
+	last := so.segments[len(so.segments)-1]
+	return so.readSeq != last.seq || so.readOff < last.size
+}
+
+func (so *SpooledOutput) append(out OutputStruct) error {
+	payload, err := json.Marshal(out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	record := make([]byte, spoolRecordHeadLen+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[spoolRecordHeadLen:], payload)
+
+	last := so.segments[len(so.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > cmp.Or(so.conf.SegmentSize, defaultSpoolSegmentSize) {
+		if err := so.createSegment(last.seq + 1); err != nil {
+			return err
+		}
+		so.advance()
+		last = so.segments[len(so.segments)-1]
+	}
+
+	if _, err := so.writer.Write(record); err != nil {
+		// drop the partial record
+		_ = so.writer.Truncate(last.size)
+		return errors.WithStack(err)
+	}
+	last.size += int64(len(record))
+	if so.conf.Fsync == config.FsyncPolicyAlways {
+		if err := so.writer.Sync(); err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		so.dirty = true
+	}
+
+	so.evict()
+	so.updateSize()
+	return nil
+}
+
+// evict removes the oldest segments until the total size is under maxSize, the segment being written is kept
+func (so *SpooledOutput) evict() {
+	maxSize := cmp.Or(so.conf.MaxSize, defaultSpoolMaxSize)
+	for len(so.segments) > 1 && so.totalSize() > maxSize {
+		log.L.Error(nil, "spool is full, evicting the oldest segment", "name", so.Name(), "segment", so.segmentPath(so.segments[0].seq))
+		so.removeOldest()
+		metrics.OutputSpoolEvictedSegments.WithLabelValues(so.labels...).Inc()
+		so.readSeq, so.readOff = so.segments[0].seq, 0
+		so.cursorDirty = true
+	}
+}
+
+// advance removes the fully replayed segments and moves the cursor to the next one
+func (so *SpooledOutput) advance() {
+	for len(so.segments) > 1 && so.readOff >= so.segments[0].size {
+		so.removeOldest()
+		so.readSeq, so.readOff = so.segments[0].seq, 0
+		so.cursorDirty = true
+	}
+	so.updateSize()
+}
+
+func (so *SpooledOutput) removeOldest() {
+	oldest := so.segments[0]
+	if so.reader != nil && so.readerSeq == oldest.seq {
+		_ = so.reader.Close()
+		so.reader = nil
+	}
+	if err := os.Remove(so.segmentPath(oldest.seq)); err != nil {
+		log.L.Error(err, "remove spool segment failed", "name", so.Name())
+	}
+	so.segments = so.segments[1:]
+}
+
+// next reads the record at the cursor, a corrupted record skips the rest of the segment
+func (so *SpooledOutput) next() (OutputStruct, int64, bool) {
+	for so.pending() {
+		seg := so.segments[0]
+		out, next, err := so.readRecord(seg)
+		if err == nil {
+			return out, next, true
+		}
+		log.L.Error(err, "corrupted spool record, skipping the rest of the segment", "name", so.Name(), "segment", so.segmentPath(seg.seq), "offset", so.readOff)
+		so.readOff = seg.size
+		so.cursorDirty = true
+		so.advance()
+	}
+	return OutputStruct{}, 0, false
+}
+
+func (so *SpooledOutput) readRecord(seg *spoolSegment) (OutputStruct, int64, error) {
+	var out OutputStruct
+	if so.reader == nil || so.readerSeq != seg.seq {
+		if so.reader != nil {
+			_ = so.reader.Close()
+		}
+		f, err := os.Open(so.segmentPath(seg.seq))
+		if err != nil {
+			so.reader = nil
+			return out, 0, errors.WithStack(err)
+		}
+		so.reader, so.readerSeq = f, seg.seq
+	}
+
+	head := make([]byte, spoolRecordHeadLen)
+	if _, err := so.reader.ReadAt(head, so.readOff); err != nil {
+		return out, 0, errors.WithStack(err)
+	}
+	length := int64(binary.BigEndian.Uint32(head[0:4]))
+	next := so.readOff + spoolRecordHeadLen + length
+	if next > seg.size {
+		return out, 0, errors.WithStack(io.ErrUnexpectedEOF)
+	}
+	payload := make([]byte, length)
+	if _, err := so.reader.ReadAt(payload, so.readOff+spoolRecordHeadLen); err != nil {
+		return out, 0, errors.WithStack(err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(head[4:8]) {
+		return out, 0, errors.New("spool record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &out); err != nil {
+		return out, 0, errors.WithStack(err)
+	}
+	return out, next, nil
+}
+
+// saveCursor persists the cursor by renaming a temp file, so the cursor file is never half written
+func (so *SpooledOutput) saveCursor() {
+	so.cursorDirty = false
+	tmp := filepath.Join(so.dir, spoolCursorFile+".tmp")
+	f, err := os.Create(tmp)
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%d %d\n", so.readSeq, so.readOff)
+		if err == nil && so.conf.Fsync != config.FsyncPolicyNever {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(so.dir, spoolCursorFile))
+	}
+	if err != nil {
+		log.L.Error(err, "save spool cursor failed", "name", so.Name())
+	}
+}
+
+func (so *SpooledOutput) totalSize() (size int64) {
+	for _, seg := range so.segments {
+		size += seg.size
+	}
+	return
+}
+
+func (so *SpooledOutput) updateSize() {
+	metrics.OutputSpoolBytes.WithLabelValues(so.labels...).Set(float64(so.totalSize()))
+}
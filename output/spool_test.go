@@ -0,0 +1,168 @@
+package output
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type flakyOutput struct {
+	mu      sync.Mutex
+	down    bool
+	written []string
+}
+
+func (fo *flakyOutput) Name() string {
+	return "flaky"
+}
+
+func (fo *flakyOutput) Write(out OutputStruct) error {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	if fo.down {
+		return assert.AnError
+	}
+	fo.written = append(fo.written, out.ObjectRef.Name)
+	return nil
+}
+
+func (fo *flakyOutput) SetDown(down bool) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	fo.down = down
+}
+
+func (fo *flakyOutput) Written() []string {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	return append([]string(nil), fo.written...)
+}
+
+// batchedOutput writes the records to the flaky output in batches
+type batchedOutput struct {
+	*flakyOutput
+	batcher *batcher[OutputStruct]
+}
+
+func newBatchedOutput(fo *flakyOutput, size int) *batchedOutput {
+	return &batchedOutput{flakyOutput: fo, batcher: newBatcher("batched", size, 0, func(outs []OutputStruct) error {
+		fo.mu.Lock()
+		defer fo.mu.Unlock()
+		if fo.down {
+			return assert.AnError
+		}
+		for _, out := range outs {
+			fo.written = append(fo.written, out.ObjectRef.Name)
+		}
+		return nil
+	})}
+}
+
+func (bo *batchedOutput) Write(out OutputStruct) error {
+	return bo.batcher.Add(out)
+}
+
+func (bo *batchedOutput) Close() error {
+	return bo.batcher.Close()
+}
+
+func (bo *batchedOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	bo.batcher.handleFailed(fn)
+	return true
+}
+
+func TestSpooledOutput(t *testing.T) {
+	record := func(name string) OutputStruct {
+		return OutputStruct{ObjectRef: corev1.ObjectReference{Name: name}, Fields: map[string]any{"name": name}}
+	}
+	conf := &config.OutputSpool{
+		Dir:           t.TempDir(),
+		SegmentSize:   200,
+		Fsync:         config.FsyncPolicyAlways,
+		RetryInterval: metav1.Duration{Duration: 10 * time.Millisecond},
+	}
+
+	// records are spooled in order while the output is down and replayed when it recovers
+	fo := &flakyOutput{}
+	so := NewSpooledOutput(0, fo, conf)
+	assert.NoError(t, so.Write(record("a")))
+	fo.SetDown(true)
+	for _, name := range []string{"b", "c", "d", "e"} {
+		assert.NoError(t, so.Write(record(name)))
+	}
+	assert.Greater(t, len(so.segments), 1)
+	fo.SetDown(false)
+	assert.NoError(t, so.Write(record("f")))
+	assert.Eventually(t, func() bool { return len(fo.Written()) == 6 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, fo.Written())
+	so.mu.Lock()
+	assert.False(t, so.pending())
+	assert.Len(t, so.segments, 1)
+	so.mu.Unlock()
+
+	// the spooled records survive a restart
+	fo2 := &flakyOutput{down: true}
+	so2 := NewSpooledOutput(1, fo2, conf)
+	assert.NoError(t, so2.Write(record("g")))
+	assert.NoError(t, so2.Write(record("h")))
+	fo3 := &flakyOutput{}
+	NewSpooledOutput(1, fo3, conf)
+	assert.Eventually(t, func() bool { return len(fo3.Written()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"g", "h"}, fo3.Written())
+
+	// the oldest segments are evicted when the spool is full
+	evictConf := *conf
+	evictConf.MaxSize = 400
+	fo4 := &flakyOutput{down: true}
+	so4 := NewSpooledOutput(2, fo4, &evictConf)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		assert.NoError(t, so4.Write(record(name)))
+	}
+	so4.mu.Lock()
+	assert.LessOrEqual(t, so4.totalSize(), int64(400))
+	so4.mu.Unlock()
+	fo4.SetDown(false)
+	so4.signal()
+	assert.Eventually(t, func() bool {
+		written := fo4.Written()
+		return len(written) > 0 && written[len(written)-1] == "h"
+	}, time.Second, time.Millisecond)
+	assert.NotContains(t, fo4.Written(), "a")
+}
+
+func TestSpooledOutput_batched(t *testing.T) {
+	record := func(name string) OutputStruct {
+		return OutputStruct{ObjectRef: corev1.ObjectReference{Name: name}}
+	}
+	conf := &config.OutputSpool{
+		Dir:           t.TempDir(),
+		Fsync:         config.FsyncPolicyAlways,
+		RetryInterval: metav1.Duration{Duration: 10 * time.Millisecond},
+	}
+
+	// the whole failed batch is spooled, not only the record failing it
+	fo := &flakyOutput{down: true}
+	so := NewSpooledOutput(0, newBatchedOutput(fo, 2), conf)
+	assert.NoError(t, so.Write(record("a")))
+	assert.NoError(t, so.Write(record("b")))
+	assert.NoError(t, so.Write(record("c")))
+	fo.SetDown(false)
+	assert.NoError(t, so.Write(record("d")))
+	assert.Eventually(t, func() bool { return len(fo.Written()) == 4 }, time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, fo.Written())
+
+	// the records of the failed flush on close are spooled and replayed after restarting
+	fo.SetDown(true)
+	assert.NoError(t, so.Write(record("e")))
+	assert.NoError(t, so.Close())
+
+	fo2 := &flakyOutput{}
+	NewSpooledOutput(0, newBatchedOutput(fo2, 1), conf)
+	assert.Eventually(t, func() bool { return len(fo2.Written()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"e"}, fo2.Written())
+}
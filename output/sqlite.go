@@ -0,0 +1,117 @@
+package output
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/gormutils"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+type SqliteOutput struct {
+	ktconfig  *config.KubeTrackConfiguration
+	conn      *dbConn
+	conf      *config.OutputSqlite
+	retention []retentionPolicy
+}
+
+func NewSqliteOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputSqlite) *SqliteOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.Path == "" {
+		log.L.Error(nil, "sqlite path not set")
+		os.Exit(1)
+	}
+	out := &SqliteOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+	}
+	out.conn = newDBConn(out.Name(), out.connect)
+	out.initCleanupJob()
+
+	return out
+}
+
+func (lo *SqliteOutput) Name() string {
+	return "sqlite"
+}
+
+func (lo *SqliteOutput) Write(out OutputStruct) error {
+	db, err := lo.conn.DB()
+	if err != nil {
+		return err
+	}
+	return insertEvents(db, lo.ktconfig.Cluster, out)
+}
+
+// open opens the database without migrating it
+func (lo *SqliteOutput) open() (*gorm.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(lo.conf.Path), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create sqlite directory failed")
+	}
+	// sqlite allows only one writer at a time
+	pool := lo.conf.DBPool
+	pool.MaxOpenConns = cmp.Or(pool.MaxOpenConns, 1)
+	return openDB("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", lo.conf.Path), pool)
+}
+
+// connect opens and migrates the database
+func (lo *SqliteOutput) connect() (*gorm.DB, error) {
+	db, err := lo.open()
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateEvents(db, false, nil); err != nil {
+		closeDB(db)
+		return nil, err
+	}
+	return db, nil
+}
+
+func (lo *SqliteOutput) initCleanupJob() {
+	lo.retention = retentionPolicies(lo.ktconfig, lo.conf.TTLDays)
+	if !hasRetention(lo.retention) {
+		return
+	}
+
+	cj := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger), cron.Recover(cron.DefaultLogger)))
+	if _, err := cj.AddFunc("0 * * * *", lo.doCleanupJob); err != nil {
+		panic(err)
+	}
+	cj.Start()
+	log.L.Info("sqlite cleanup job started, will run every hour", "ttl", lo.conf.TTLDays)
+}
+
+func (lo *SqliteOutput) doCleanupJob() {
+	log.L.Info("running cleanup job", "ttlDays", lo.conf.TTLDays)
+	db, err := lo.conn.DB()
+	if err != nil {
+		log.L.Error(err, "cron: skip cleanup job")
+		return
+	}
+	// nothing is deleted if the holds are unknown
+	holds, err := syncHolds(db, lo.ktconfig)
+	if err != nil {
+		log.L.Error(err, "cron: skip cleanup job")
+		return
+	}
+	exempt := newRetentionExemption(db.Dialector.Name(), holds, lo.ktconfig)
+	// the kept records are looked up once in a temporary table of the connection
+	if err := gormutils.WithConn(db, func(tx *gorm.DB) error {
+		if err := exempt.saveKept(tx, "events"); err != nil {
+			return err
+		}
+		defer exempt.dropKept(tx)
+		return deleteExpiredEvents(tx, lo.retention, exempt, time.Now())
+	}); err != nil {
+		log.L.Error(err, "cron: delete data failed")
+	}
+}
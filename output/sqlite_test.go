@@ -0,0 +1,136 @@
+package output
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func sqliteDB(t *testing.T, out *SqliteOutput) *gorm.DB {
+	db, err := out.conn.DB()
+	require.NoError(t, err)
+	return db
+}
+
+// tempSqliteDB opens a database in the temp dir of the test, closed when the test finishes
+func tempSqliteDB(t *testing.T, migrate bool) *gorm.DB {
+	out := &SqliteOutput{conf: &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "kubetrack.db")}}
+	db, err := out.open()
+	require.NoError(t, err)
+	t.Cleanup(func() { closeDB(db) })
+	if migrate {
+		require.NoError(t, migrateEvents(db, false, nil))
+	}
+	return db
+}
+
+func TestSqliteOutput(t *testing.T) {
+	ktconfig := &config.KubeTrackConfiguration{Cluster: "test"}
+	out := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "kubetrack.db"), TTLDays: 1})
+
+	err := out.Write(OutputStruct{
+		EventTime: time.Now(),
+		ObjectRef: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "nginx", UID: "uid-1"},
+		EventType: EventTypeAdd,
+		Source:    SourceTypeGeneral,
+		Fields:    map[string]any{"phase": "Running"},
+	})
+	require.NoError(t, err)
+
+	var events []Events
+	require.NoError(t, sqliteDB(t, out).Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Equal(t, "test", events[0].Cluster)
+	assert.Equal(t, "nginx", events[0].Name)
+	assert.JSONEq(t, `{"phase": "Running"}`, string(events[0].Fields))
+
+	// records within ttl are kept
+	out.doCleanupJob()
+	require.NoError(t, sqliteDB(t, out).Find(&events).Error)
+	assert.Len(t, events, 1)
+}
+
+func TestSqliteOutput_independentDB(t *testing.T) {
+	ktconfig := &config.KubeTrackConfiguration{Cluster: "test"}
+	out1 := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "1.db")})
+	out2 := NewSqliteOutput(ktconfig, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "2.db")})
+
+	require.NoError(t, out1.Write(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: "nginx"}}))
+
+	var count1, count2 int64
+	require.NoError(t, sqliteDB(t, out1).Model(&Events{}).Count(&count1).Error)
+	require.NoError(t, sqliteDB(t, out2).Model(&Events{}).Count(&count2).Error)
+	assert.Equal(t, int64(1), count1)
+	assert.Equal(t, int64(0), count2)
+}
+
+func TestDBBatcher(t *testing.T) {
+	out := NewSqliteOutput(&config.KubeTrackConfiguration{Cluster: "test"}, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "kubetrack.db")})
+	b := newDBBatcher("sqlite", out.conn, "test", 3, time.Hour)
+
+	count := func() (n int64) {
+		require.NoError(t, sqliteDB(t, out).Model(&Events{}).Count(&n).Error)
+		return
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, b.Add(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: name}}))
+	}
+	assert.Equal(t, int64(3), count())
+	require.NoError(t, b.Flush())
+	assert.Equal(t, int64(4), count())
+
+	// the bad records are dropped without failing the others of their batches
+	require.NoError(t, sqliteDB(t, out).Exec("CREATE TRIGGER reject_bad BEFORE INSERT ON events WHEN NEW.name = 'bad' "+
+		"BEGIN SELECT RAISE(ABORT, 'bad record'); END").Error)
+	for _, name := range []string{"e", "bad", "f", "g", "h"} {
+		require.NoError(t, b.Add(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: name}}))
+	}
+	assert.Equal(t, int64(6), count())
+	err := b.Add(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: "bad"}})
+	assert.True(t, isPermanent(err))
+	assert.Equal(t, int64(8), count())
+	assert.Empty(t, b.items)
+	require.NoError(t, b.Close())
+	require.NoError(t, b.Close())
+
+	assert.Nil(t, newDBBatcher("sqlite", out.conn, "test", 1, 0))
+}
+
+func TestSqliteOutput_degraded(t *testing.T) {
+	// the directory of the database is a file, so the database can't be opened
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0o644))
+	out := &SqliteOutput{
+		ktconfig: &config.KubeTrackConfiguration{Cluster: "test"},
+		conf:     &config.OutputSqlite{Path: filepath.Join(dir, "file", "kubetrack.db")},
+	}
+	out.conn = newDBConn(out.Name(), out.connect)
+
+	err := out.Write(OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: "nginx"}})
+	assert.ErrorIs(t, err, ErrDBNotReady)
+	assert.Error(t, out.conn.check(context.Background()))
+}
+
+func TestSqliteOutput_idempotency(t *testing.T) {
+	out := NewSqliteOutput(&config.KubeTrackConfiguration{Cluster: "test"}, &config.OutputSqlite{Path: filepath.Join(t.TempDir(), "kubetrack.db")})
+	key := NewIdempotencyKey("test", SourceTypeGeneral, EventTypeUpdate, "uid-1", "100")
+	record := OutputStruct{EventTime: time.Now(), ObjectRef: corev1.ObjectReference{Name: "nginx"}, IdempotencyKey: key}
+
+	require.NoError(t, out.Write(record))
+	require.NoError(t, out.Write(record))
+	// records without the key are never deduplicated
+	require.NoError(t, out.Write(OutputStruct{EventTime: time.Now()}))
+	require.NoError(t, out.Write(OutputStruct{EventTime: time.Now()}))
+
+	var count int64
+	require.NoError(t, sqliteDB(t, out).Model(&Events{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
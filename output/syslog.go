@@ -0,0 +1,235 @@
+package output
+
+import (
+	"cmp"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSyslogFacility     = 16 // local0
+	defaultSyslogAppName      = "kubetrack"
+	defaultSyslogEnterpriseID = 32473
+	defaultSyslogTimeout      = 10 * time.Second
+
+	// RFC 5424 allows at most 6 digits of the fractional seconds
+	syslogTimestampLayout = "2006-01-02T15:04:05.999999Z07:00"
+)
+
+// syslog severities
+const (
+	syslogSeverityWarning = 4
+	syslogSeverityNotice  = 5
+	syslogSeverityInfo    = 6
+)
+
+type SyslogOutput struct {
+	ktconfig  *config.KubeTrackConfiguration
+	conf      *config.OutputSyslog
+	tlsConfig *tls.Config
+	hostname  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputSyslog) *SyslogOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.Address == "" {
+		log.L.Error(nil, "syslog address not set")
+		os.Exit(1)
+	}
+	switch conf.Network {
+	case "", "udp", "tcp", "tls":
+	default:
+		log.L.Error(nil, "unknown syslog network", "network", conf.Network)
+		os.Exit(1)
+	}
+
+	tlsConfig, err := newTLSConfig(conf.TLS)
+	if err != nil {
+		log.L.Error(err, "init syslog tls config failed")
+		os.Exit(1)
+	}
+
+	return &SyslogOutput{
+		ktconfig:  ktconfig,
+		conf:      conf,
+		tlsConfig: tlsConfig,
+		hostname:  cmp.Or(conf.Hostname, ktconfig.Cluster, "-"),
+	}
+}
+
+func (so *SyslogOutput) Name() string {
+	return "syslog"
+}
+
+func (so *SyslogOutput) Write(out OutputStruct) error {
+	msg := so.format(out, time.Now())
+
+	so.mu.Lock()
+	defer so.mu.Unlock()
+
+	// reconnect once if the connection was broken
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = so.write(msg); err == nil {
+			return nil
+		}
+		if so.conn != nil {
+			_ = so.conn.Close()
+			so.conn = nil
+		}
+	}
+	return err
+}
+
+func (so *SyslogOutput) write(msg string) error {
+	if so.conn == nil {
+		conn, err := so.dial()
+		if err != nil {
+			return err
+		}
+		so.conn = conn
+	}
+
+	// stream transports need framing, use the octet counting method of RFC 6587
+	if so.conf.Network == "tcp" || so.conf.Network == "tls" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	_ = so.conn.SetWriteDeadline(time.Now().Add(cmp.Or(so.conf.Timeout.Duration, defaultSyslogTimeout)))
+	_, err := so.conn.Write([]byte(msg))
+	return errors.Wrap(err, "write syslog message failed")
+}
+
+func (so *SyslogOutput) dial() (net.Conn, error) {
+	timeout := cmp.Or(so.conf.Timeout.Duration, defaultSyslogTimeout)
+	switch so.conf.Network {
+	case "tls":
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", so.conf.Address, so.tlsConfig)
+		return conn, errors.Wrap(err, "dial syslog server failed")
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", so.conf.Address, timeout)
+		return conn, errors.Wrap(err, "dial syslog server failed")
+	default:
+		conn, err := net.DialTimeout("udp", so.conf.Address, timeout)
+		return conn, errors.Wrap(err, "dial syslog server failed")
+	}
+}
+
+// format builds the RFC 5424 message:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (so *SyslogOutput) format(out OutputStruct, now time.Time) string {
+	facility := defaultSyslogFacility
+	if so.conf.Facility != nil {
+		facility = *so.conf.Facility
+	}
+	pri := facility*8 + so.severity(out)
+
+	timestamp := out.EventTime
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	msgID := syslogHeaderField(fmt.Sprintf("%s-%s", out.Source, out.EventType), 32)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<%d>1 %s %s %s %d %s ", pri, timestamp.Format(syslogTimestampLayout),
+		syslogHeaderField(so.hostname, 255), syslogHeaderField(cmp.Or(so.conf.AppName, defaultSyslogAppName), 48), os.Getpid(), msgID)
+
+	so.writeStructuredData(&sb, out)
+
+	sb.WriteString(" ")
+	sb.WriteString(out.Summary())
+	return sb.String()
+}
+
+func (so *SyslogOutput) writeStructuredData(sb *strings.Builder, out OutputStruct) {
+	enterpriseID := cmp.Or(so.conf.EnterpriseID, defaultSyslogEnterpriseID)
+
+	fmt.Fprintf(sb, "[objectRef@%d", enterpriseID)
+	writeSDParam(sb, "cluster", so.ktconfig.Cluster)
+	writeSDParam(sb, "apiVersion", out.ObjectRef.APIVersion)
+	writeSDParam(sb, "kind", out.ObjectRef.Kind)
+	writeSDParam(sb, "namespace", out.ObjectRef.Namespace)
+	writeSDParam(sb, "name", out.ObjectRef.Name)
+	writeSDParam(sb, "uid", string(out.ObjectRef.UID))
+	sb.WriteString("]")
+
+	if len(out.Fields) == 0 {
+		return
+	}
+	names := make([]string, 0, len(out.Fields))
+	for name := range out.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(sb, "[fields@%d", enterpriseID)
+	for _, name := range names {
+		writeSDParam(sb, name, formatFieldValue(out.Fields[name]))
+	}
+	sb.WriteString("]")
+}
+
+// severity derives the severity from the record, the Warning events are warning,
+// deletions are notice, and the others are informational
+func (so *SyslogOutput) severity(out OutputStruct) int {
+	if out.IsWarning() {
+		return syslogSeverityWarning
+	}
+	if out.Source == SourceTypeGeneral && out.EventType == EventTypeDelete {
+		return syslogSeverityNotice
+	}
+	return syslogSeverityInfo
+}
+
+func writeSDParam(sb *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+
+	// PARAM-NAME is printable US-ASCII except '=', SP, ']', '"', at most 32 characters
+	name = strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	// '"', '\' and ']' must be escaped in PARAM-VALUE
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	fmt.Fprintf(sb, ` %s="%s"`, name, value)
+}
+
+// syslogHeaderField makes the header field printable US-ASCII without spaces
+func syslogHeaderField(s string, maxLen int) string {
+	if s == "" {
+		return "-"
+	}
+	s = strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	return s
+}
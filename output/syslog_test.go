@@ -0,0 +1,55 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSyslogOutput_format(t *testing.T) {
+	so := NewSyslogOutput(&config.KubeTrackConfiguration{Cluster: "prod"}, &config.OutputSyslog{Address: "127.0.0.1:514"})
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	objectRef := corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "nginx", UID: "uid-1"}
+
+	// general update
+	msg := so.format(OutputStruct{
+		EventTime: eventTime,
+		ObjectRef: objectRef,
+		EventType: EventTypeUpdate,
+		Source:    SourceTypeGeneral,
+		Fields:    map[string]any{"status": `Running "ok"`, "node name": "node]1"},
+	}, eventTime)
+	assert.Equal(t, fmt.Sprintf(`<134>1 2024-01-02T03:04:05Z prod kubetrack %d general-update `+
+		`[objectRef@32473 cluster="prod" apiVersion="v1" kind="Pod" namespace="default" name="nginx" uid="uid-1"]`+
+		`[fields@32473 node_name="node\]1" status="Running \"ok\""] update Pod default/nginx`, os.Getpid()), msg)
+
+	// warning event
+	msg = so.format(OutputStruct{
+		EventTime: eventTime,
+		ObjectRef: objectRef,
+		EventType: EventTypeAdd,
+		Source:    SourceTypeEvent,
+		Object:    map[string]any{"type": "Warning"},
+		Message:   "Warning BackOff Back-off restarting failed container",
+	}, eventTime)
+	assert.Equal(t, fmt.Sprintf(`<132>1 2024-01-02T03:04:05Z prod kubetrack %d event-add `+
+		`[objectRef@32473 cluster="prod" apiVersion="v1" kind="Pod" namespace="default" name="nginx" uid="uid-1"] `+
+		`Warning BackOff Back-off restarting failed container`, os.Getpid()), msg)
+
+	// nanosecond timestamp and nested fields
+	msg = so.format(OutputStruct{
+		EventTime: time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC),
+		ObjectRef: objectRef,
+		EventType: EventTypeUpdate,
+		Source:    SourceTypeGeneral,
+		Fields:    map[string]any{"labels": map[string]any{"app": "nginx"}, "ports": []any{80, 443}, "ready": true},
+	}, eventTime)
+	assert.Equal(t, fmt.Sprintf(`<134>1 2024-01-02T03:04:05.123456Z prod kubetrack %d general-update `+
+		`[objectRef@32473 cluster="prod" apiVersion="v1" kind="Pod" namespace="default" name="nginx" uid="uid-1"]`+
+		`[fields@32473 labels="{\"app\":\"nginx\"}" ports="[80,443\]" ready="true"] update Pod default/nginx`, os.Getpid()), msg)
+}
@@ -0,0 +1,42 @@
+package output
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/pkg/errors"
+)
+
+func newTLSConfig(conf *config.TLSConfig) (*tls.Config, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: conf.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if conf.CAFile != "" {
+		caPEM, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca file failed")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("no certificate found in ca file: %s", conf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate failed")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
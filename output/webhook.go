@@ -0,0 +1,185 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/major1201/kubetrack/config"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/tmpl"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebhookSignatureHeader = "X-Kubetrack-Signature"
+	defaultWebhookBatchInterval   = 5 * time.Second
+)
+
+type WebhookOutput struct {
+	ktconfig *config.KubeTrackConfiguration
+	conf     *config.OutputWebhook
+	client   *http.Client
+	batcher  *batcher[OutputStruct]
+}
+
+func NewWebhookOutput(ktconfig *config.KubeTrackConfiguration, conf *config.OutputWebhook) *WebhookOutput {
+	if conf == nil {
+		return nil
+	}
+	if conf.URL == "" {
+		log.L.Error(nil, "webhook url not set")
+		os.Exit(1)
+	}
+
+	if err := validateCloudEventsMode(conf.CloudEvents); err != nil {
+		log.L.Error(err, "invalid webhook config")
+		os.Exit(1)
+	}
+	if conf.CloudEvents != "" && conf.BodyTemplate != "" {
+		log.L.Error(nil, "webhook bodyTemplate is not allowed with cloudEvents")
+		os.Exit(1)
+	}
+	if conf.CloudEvents == config.CloudEventsModeBinary && conf.BatchSize > 1 {
+		log.L.Error(nil, "webhook batching is not supported by cloudEvents binary mode")
+		os.Exit(1)
+	}
+
+	client, err := newHTTPClient(conf.TLS, conf.Timeout.Duration)
+	if err != nil {
+		log.L.Error(err, "init webhook http client failed")
+		os.Exit(1)
+	}
+
+	out := &WebhookOutput{
+		ktconfig: ktconfig,
+		conf:     conf,
+		client:   client,
+	}
+	if conf.BatchSize > 1 {
+		out.batcher = newBatcher(out.Name(), conf.BatchSize, cmp.Or(conf.BatchInterval.Duration, defaultWebhookBatchInterval), out.sendBatch)
+	}
+	return out
+}
+
+func (wo *WebhookOutput) Name() string {
+	return "webhook"
+}
+
+func (wo *WebhookOutput) Write(out OutputStruct) error {
+	if wo.batcher != nil {
+		return wo.batcher.Add(out)
+	}
+	return wo.send(out)
+}
+
+// Close sends the pending records
+func (wo *WebhookOutput) Close() error {
+	if wo.batcher != nil {
+		return wo.batcher.Close()
+	}
+	return nil
+}
+
+func (wo *WebhookOutput) handleFailed(fn func(records []OutputStruct)) bool {
+	if wo.batcher == nil {
+		return false
+	}
+	wo.batcher.handleFailed(fn)
+	return true
+}
+
+func (wo *WebhookOutput) sendBatch(outs []OutputStruct) error {
+	return wo.send(outs)
+}
+
+func (wo *WebhookOutput) send(data any) error {
+	body, header, err := wo.encode(data)
+	if err != nil {
+		return err
+	}
+
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(cmp.Or(wo.conf.Method, http.MethodPost), wo.conf.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		for k, v := range wo.conf.Headers {
+			req.Header.Set(k, v)
+		}
+		if wo.conf.HMACSecret != "" {
+			req.Header.Set(cmp.Or(wo.conf.SignatureHeader, defaultWebhookSignatureHeader), "sha256="+wo.sign(body))
+		}
+		return req, nil
+	}
+
+	maxRetries := defaultHTTPMaxRetries
+	if wo.conf.MaxRetries != nil {
+		maxRetries = *wo.conf.MaxRetries
+	}
+	_, err = doHTTPWithRetry(wo.client, newReq, maxRetries, cmp.Or(wo.conf.RetryBackoff.Duration, defaultHTTPRetryBackoff))
+	return err
+}
+
+// encode returns the request body and the content headers of the record or the batch of records
+func (wo *WebhookOutput) encode(data any) ([]byte, http.Header, error) {
+	header := http.Header{}
+	switch wo.conf.CloudEvents {
+	case config.CloudEventsModeStructured:
+		var ce any
+		switch v := data.(type) {
+		case OutputStruct:
+			ce = NewCloudEvent(wo.ktconfig.Cluster, v)
+			header.Set("Content-Type", CloudEventsContentType)
+		case []OutputStruct:
+			events := make([]CloudEvent, 0, len(v))
+			for _, out := range v {
+				events = append(events, NewCloudEvent(wo.ktconfig.Cluster, out))
+			}
+			ce = events
+			header.Set("Content-Type", CloudEventsBatchContentType)
+		}
+		body, err := json.Marshal(ce)
+		return body, header, errors.Wrap(err, "marshal webhook cloudevents body failed")
+	case config.CloudEventsModeBinary:
+		ce := NewCloudEvent(wo.ktconfig.Cluster, data.(OutputStruct))
+		for k, v := range ce.Attributes() {
+			header.Set("ce-"+k, v)
+		}
+		header.Set("Content-Type", ce.DataContentType)
+		body, err := json.Marshal(ce.Data)
+		return body, header, errors.Wrap(err, "marshal webhook cloudevents data failed")
+	default:
+		header.Set("Content-Type", "application/json")
+		body, err := wo.renderBody(data)
+		return body, header, err
+	}
+}
+
+func (wo *WebhookOutput) renderBody(data any) ([]byte, error) {
+	if wo.conf.BodyTemplate == "" {
+		body, err := json.Marshal(data)
+		return body, errors.Wrap(err, "marshal webhook body failed")
+	}
+
+	body, err := tmpl.ExecuteTextTemplate(wo.conf.BodyTemplate, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "render webhook body template failed")
+	}
+	return []byte(body), nil
+}
+
+func (wo *WebhookOutput) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(wo.conf.HMACSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
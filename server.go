@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/major1201/kubetrack/health"
+	"github.com/major1201/kubetrack/log"
+	"github.com/major1201/kubetrack/metrics"
+	"github.com/major1201/kubetrack/output"
+	"github.com/pkg/errors"
+)
+
+// serveHTTP starts the http server of the metrics and health endpoints in the background, it is disabled if addr is empty
+func serveHTTP(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler())
+	go func() {
+		log.L.Info("serving metrics and health endpoints", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.L.Error(err, "serve http failed")
+		}
+	}()
+}
+
+// serveHoldAPI starts the http server of the legal hold api in the background, it is disabled if addr is empty,
+// the requests are authenticated by the tokens in tokenFile, and it's served over tls if the cert and the key are set
+func serveHoldAPI(addr, tokenFile string, trustRemoteUser bool, certFile, keyFile string) error {
+	if addr == "" {
+		return nil
+	}
+	if tokenFile == "" {
+		return errors.New("hold-api-token-file is required by the hold api")
+	}
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("both hold-api-tls-cert-file and hold-api-tls-key-file are required to serve the hold api over tls")
+	}
+	tokens, err := output.LoadHoldAPITokens(tokenFile)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	holds := output.HoldHandler(output.HoldAPIAuth{Tokens: tokens, TrustRemoteUser: trustRemoteUser})
+	mux.Handle("/holds", holds)
+	mux.Handle("/holds/", holds)
+	go func() {
+		log.L.Info("serving legal hold api", "addr", addr, "tls", certFile != "")
+		var err error
+		if certFile != "" {
+			err = http.ListenAndServeTLS(addr, certFile, keyFile, mux)
+		} else {
+			err = http.ListenAndServe(addr, mux)
+		}
+		log.L.Error(err, "serve legal hold api failed")
+	}()
+	return nil
+}